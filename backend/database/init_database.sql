@@ -6,57 +6,189 @@ $$ LANGUAGE plpgsql;
 -- Users --
 CREATE TABLE IF NOT EXISTS users (
     user_id TEXT PRIMARY KEY,
-    hashed_password TEXT NOT NULL,
-    created_at TIMESTAMP DEFAULT NOW(),
-    updated_at TIMESTAMP DEFAULT NOW()
+    hashed_password TEXT,
+    email TEXT,
+    display_name TEXT,
+    role TEXT NOT NULL DEFAULT 'member',
+    disabled BOOLEAN NOT NULL DEFAULT FALSE,
+    time_zone TEXT NOT NULL DEFAULT 'UTC',
+    max_timer_duration INTEGER,
+    rounding_mode TEXT,
+    rounding_increment INTEGER,
+    rounding_minimum INTEGER,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
 );
-CREATE TRIGGER update_users_modtime BEFORE
+CREATE OR REPLACE TRIGGER update_users_modtime BEFORE
 UPDATE ON users FOR EACH ROW EXECUTE FUNCTION update_modified_column();
 -- Sessions --
 CREATE TABLE IF NOT EXISTS sessions (
     session_id TEXT PRIMARY KEY,
+    public_id SERIAL UNIQUE,
     user_id TEXT NOT NULL,
-    expires_at TIMESTAMP NOT NULL,
-    created_at TIMESTAMP DEFAULT NOW(),
-    updated_at TIMESTAMP DEFAULT NOW(),
+    user_agent TEXT,
+    ip TEXT,
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    idle_timeout INTEGER NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    max_expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
     FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
 );
-CREATE TRIGGER update_sessions_modtime BEFORE
+CREATE OR REPLACE TRIGGER update_sessions_modtime BEFORE
 UPDATE ON sessions FOR EACH ROW EXECUTE FUNCTION update_modified_column();
 -- Projects --
 CREATE TABLE IF NOT EXISTS projects (
     project_id SERIAL PRIMARY KEY,
     user_id TEXT NOT NULL,
     name TEXT NOT NULL,
-    started_at TIMESTAMP,
-    created_at TIMESTAMP DEFAULT NOW(),
-    updated_at TIMESTAMP DEFAULT NOW(),
+    started_at TIMESTAMPTZ,
+    paused_at TIMESTAMPTZ,
+    rounding_mode TEXT,
+    rounding_increment INTEGER,
+    rounding_minimum INTEGER,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
     FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE,
     UNIQUE(user_id, name)
 );
-CREATE TRIGGER update_projects_modtime BEFORE
+CREATE OR REPLACE TRIGGER update_projects_modtime BEFORE
 UPDATE ON projects FOR EACH ROW EXECUTE FUNCTION update_modified_column();
 CREATE TABLE IF NOT EXISTS activities (
     activity_id SERIAL PRIMARY KEY,
+    user_id TEXT NOT NULL,
     project_id SERIAL NOT NULL,
-    started_at TIMESTAMP,
-    ended_at TIMESTAMP,
-    created_at TIMESTAMP DEFAULT NOW(),
-    updated_at TIMESTAMP DEFAULT NOW(),
+    started_at TIMESTAMPTZ,
+    ended_at TIMESTAMPTZ,
+    auto_stopped BOOLEAN NOT NULL DEFAULT FALSE,
+    last_seen_at TIMESTAMPTZ,
+    paused BOOLEAN NOT NULL DEFAULT FALSE,
+    planned_duration INTEGER,
+    focus_auto_stop BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
+    FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE,
     FOREIGN KEY (project_id) REFERENCES projects (project_id) ON DELETE CASCADE
 );
-CREATE TRIGGER update_activities_modtime BEFORE
+-- only one activity of a user may be running at a time
+CREATE UNIQUE INDEX IF NOT EXISTS activities_one_running_per_user ON activities (user_id)
+WHERE ended_at IS NULL;
+CREATE OR REPLACE TRIGGER update_activities_modtime BEFORE
 UPDATE ON activities FOR EACH ROW EXECUTE FUNCTION update_modified_column();
 -- Worktime --
 CREATE TABLE IF NOT EXISTS worktime (
     user_id TEXT NOT NULL,
-    day TIMESTAMP NOT NULL,
+    day DATE NOT NULL,
     work_time INTEGER NOT NULL,
     break_time INTEGER NOT NULL DEFAULT 0,
-    created_at TIMESTAMP DEFAULT NOW(),
-    updated_at TIMESTAMP DEFAULT NOW(),
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
     FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE,
     PRIMARY KEY (user_id, day)
 );
-CREATE TRIGGER update_worktime_modtime BEFORE
-UPDATE ON worktime FOR EACH ROW EXECUTE FUNCTION update_modified_column();
\ No newline at end of file
+CREATE OR REPLACE TRIGGER update_worktime_modtime BEFORE
+UPDATE ON worktime FOR EACH ROW EXECUTE FUNCTION update_modified_column();
+-- Overtime --
+CREATE TABLE IF NOT EXISTS overtime (
+    user_id TEXT PRIMARY KEY,
+    balance BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
+    FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
+CREATE OR REPLACE TRIGGER update_overtime_modtime BEFORE
+UPDATE ON overtime FOR EACH ROW EXECUTE FUNCTION update_modified_column();
+-- Idempotency keys --
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id TEXT NOT NULL,
+    idempotency_key TEXT NOT NULL,
+    request TEXT NOT NULL,
+    -- NULL while the request is still running
+    status_code INTEGER,
+    response_body BYTEA,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, idempotency_key)
+);
+-- Access tokens --
+CREATE TABLE IF NOT EXISTS access_tokens (
+    token_id SERIAL PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    scopes TEXT [] NOT NULL,
+    expires_at TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
+    FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE,
+    UNIQUE(user_id, name)
+);
+CREATE OR REPLACE TRIGGER update_access_tokens_modtime BEFORE
+UPDATE ON access_tokens FOR EACH ROW EXECUTE FUNCTION update_modified_column();
+-- Password resets --
+CREATE TABLE IF NOT EXISTS password_resets (
+    token_hash TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
+-- Login attempts --
+CREATE TABLE IF NOT EXISTS login_attempts (
+    kind TEXT NOT NULL,
+    key TEXT NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    locked_until TIMESTAMPTZ,
+    last_failed_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (kind, key)
+);
+-- Two-factor authentication --
+CREATE TABLE IF NOT EXISTS totp_secrets (
+    user_id TEXT PRIMARY KEY,
+    secret TEXT NOT NULL,
+    confirmed BOOLEAN NOT NULL DEFAULT FALSE,
+    last_used_step BIGINT,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
+    FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
+CREATE OR REPLACE TRIGGER update_totp_secrets_modtime BEFORE
+UPDATE ON totp_secrets FOR EACH ROW EXECUTE FUNCTION update_modified_column();
+CREATE TABLE IF NOT EXISTS totp_recovery_codes (
+    user_id TEXT NOT NULL,
+    code_hash TEXT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    FOREIGN KEY (user_id) REFERENCES totp_secrets (user_id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, code_hash)
+);
+CREATE TABLE IF NOT EXISTS login_challenges (
+    challenge_hash TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
+-- OIDC login states --
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+    state_hash TEXT PRIMARY KEY,
+    nonce TEXT NOT NULL,
+    verifier TEXT NOT NULL,
+    remember_me BOOLEAN NOT NULL DEFAULT FALSE,
+    link_user_id TEXT,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    FOREIGN KEY (link_user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
+-- User identities --
+CREATE TABLE IF NOT EXISTS user_identities (
+    identity_id SERIAL PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    email TEXT,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE,
+    UNIQUE(issuer, subject)
+);
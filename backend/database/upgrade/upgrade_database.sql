@@ -0,0 +1,175 @@
+-- Upgrades a database, which was created by an earlier version, to the current
+-- schema. The script can be run repeatedly, as every step is skipped, if it
+-- was already applied.
+\set ON_ERROR_STOP on
+-- Timestamps were stored without time zone in the zone of the database server,
+-- which is passed as old_time_zone (e.g. -v old_time_zone=Europe/Berlin).
+\if :{?old_time_zone}
+\else
+\set old_time_zone UTC
+\endif
+SET upgrade.old_time_zone = :'old_time_zone';
+BEGIN;
+-- Existing tables are altered first, so that the schema can be created on top
+-- of them.
+-- Time zones --
+DO $$
+DECLARE old_time_zone TEXT := current_setting('upgrade.old_time_zone');
+timestamp_column RECORD;
+BEGIN IF NOT EXISTS (
+    SELECT
+    FROM information_schema.columns
+    WHERE table_schema = current_schema()
+        AND table_name = 'users'
+        AND column_name = 'time_zone'
+) THEN
+ALTER TABLE users
+ADD COLUMN time_zone TEXT NOT NULL DEFAULT 'UTC';
+-- existing users keep the day boundaries of the server
+UPDATE users
+SET time_zone = old_time_zone;
+END IF;
+IF EXISTS (
+    SELECT
+    FROM information_schema.columns
+    WHERE table_schema = current_schema()
+        AND table_name = 'worktime'
+        AND column_name = 'day'
+        AND data_type = 'timestamp without time zone'
+) THEN
+ALTER TABLE worktime
+ALTER COLUMN day TYPE DATE USING day::date;
+END IF;
+FOR timestamp_column IN
+SELECT table_name,
+    column_name
+FROM information_schema.columns
+WHERE table_schema = current_schema()
+    AND table_name IN (
+        'users',
+        'sessions',
+        'projects',
+        'activities',
+        'worktime'
+    )
+    AND data_type = 'timestamp without time zone' LOOP EXECUTE format(
+        'ALTER TABLE %I ALTER COLUMN %I TYPE TIMESTAMPTZ USING %I AT TIME ZONE %L',
+        timestamp_column.table_name,
+        timestamp_column.column_name,
+        timestamp_column.column_name,
+        old_time_zone
+    );
+END LOOP;
+END $$;
+-- Auto stop --
+ALTER TABLE users
+ADD COLUMN IF NOT EXISTS max_timer_duration INTEGER;
+ALTER TABLE activities
+ADD COLUMN IF NOT EXISTS auto_stopped BOOLEAN NOT NULL DEFAULT FALSE;
+-- Idle detection --
+ALTER TABLE activities
+ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ;
+-- Pauses --
+ALTER TABLE projects
+ADD COLUMN IF NOT EXISTS paused_at TIMESTAMPTZ;
+ALTER TABLE activities
+ADD COLUMN IF NOT EXISTS paused BOOLEAN NOT NULL DEFAULT FALSE;
+-- Focus sessions --
+ALTER TABLE activities
+ADD COLUMN IF NOT EXISTS planned_duration INTEGER;
+ALTER TABLE activities
+ADD COLUMN IF NOT EXISTS focus_auto_stop BOOLEAN NOT NULL DEFAULT FALSE;
+-- Rounding --
+ALTER TABLE users
+ADD COLUMN IF NOT EXISTS rounding_mode TEXT,
+    ADD COLUMN IF NOT EXISTS rounding_increment INTEGER,
+    ADD COLUMN IF NOT EXISTS rounding_minimum INTEGER;
+ALTER TABLE projects
+ADD COLUMN IF NOT EXISTS rounding_mode TEXT,
+    ADD COLUMN IF NOT EXISTS rounding_increment INTEGER,
+    ADD COLUMN IF NOT EXISTS rounding_minimum INTEGER;
+-- Single running timer --
+-- activities belong to the user directly, so that the open activities of a
+-- user can be constrained by an index
+ALTER TABLE activities
+ADD COLUMN IF NOT EXISTS user_id TEXT REFERENCES users (user_id) ON DELETE CASCADE;
+UPDATE activities a
+SET user_id = p.user_id
+FROM projects p
+WHERE a.project_id = p.project_id
+    AND a.user_id IS NULL;
+ALTER TABLE activities
+ALTER COLUMN user_id
+SET NOT NULL;
+-- concurrent starts could leave several activities of a user open, which are
+-- closed at the start of the next one
+UPDATE activities a
+SET ended_at = o.next_started_at,
+    auto_stopped = TRUE
+FROM (
+        SELECT activity_id,
+            LEAD(started_at) OVER (
+                PARTITION BY user_id
+                ORDER BY started_at,
+                    activity_id
+            ) AS next_started_at
+        FROM activities
+        WHERE ended_at IS NULL
+    ) o
+WHERE a.activity_id = o.activity_id
+    AND o.next_started_at IS NOT NULL;
+UPDATE projects p
+SET started_at = NULL
+WHERE p.started_at IS NOT NULL
+    AND NOT EXISTS (
+        SELECT
+        FROM activities a
+        WHERE a.project_id = p.project_id
+            AND a.ended_at IS NULL
+    );
+-- Active sessions --
+ALTER TABLE sessions
+ADD COLUMN IF NOT EXISTS public_id SERIAL UNIQUE,
+    ADD COLUMN IF NOT EXISTS user_agent TEXT,
+    ADD COLUMN IF NOT EXISTS ip TEXT,
+    ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+-- Sliding sessions --
+-- existing sessions keep their expiry and get the default idle timeout of 24h
+ALTER TABLE sessions
+ADD COLUMN IF NOT EXISTS idle_timeout INTEGER NOT NULL DEFAULT 86400,
+    ADD COLUMN IF NOT EXISTS max_expires_at TIMESTAMPTZ;
+ALTER TABLE sessions
+ALTER COLUMN idle_timeout DROP DEFAULT;
+UPDATE sessions
+SET max_expires_at = expires_at
+WHERE max_expires_at IS NULL;
+ALTER TABLE sessions
+ALTER COLUMN max_expires_at
+SET NOT NULL;
+-- Single sign-on --
+-- provisioned users log in only via the identity provider
+ALTER TABLE users
+ALTER COLUMN hashed_password DROP NOT NULL;
+ALTER TABLE users
+ADD COLUMN IF NOT EXISTS email TEXT,
+    ADD COLUMN IF NOT EXISTS display_name TEXT,
+    ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'member';
+-- User administration --
+ALTER TABLE users
+ADD COLUMN IF NOT EXISTS disabled BOOLEAN NOT NULL DEFAULT FALSE;
+\ir ../init_database.sql
+-- Overtime --
+-- the running balance is initialized from the worktime tracked so far, the
+-- 8 hours are the expected worktime per day
+INSERT INTO overtime (user_id, balance)
+SELECT user_id,
+    SUM(work_time - break_time - 8 * 60 * 60)
+FROM worktime
+GROUP BY user_id ON CONFLICT (user_id) DO
+UPDATE
+SET balance = EXCLUDED.balance;
+-- Idempotency keys --
+-- keys are reserved before the request runs, so the status is empty meanwhile
+ALTER TABLE idempotency_keys
+ALTER COLUMN status_code DROP NOT NULL;
+COMMIT;
@@ -38,7 +38,10 @@ type actionFunc func(w http.ResponseWriter, r *http.Request, id int) error
 
 func (a *apiImpl) HTTPHandler(w http.ResponseWriter, r *http.Request) {
 	actionMap := map[string]actionFunc{
-		"": a.handleNoAction,
+		"":             a.handleNoAction,
+		"discard-idle": a.handleDiscardIdleAction,
+		"split":        a.handleSplitAction,
+		"merge":        a.handleMergeAction,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -81,10 +84,30 @@ func (a *apiImpl) HTTPHandler(w http.ResponseWriter, r *http.Request) {
 
 	err = actionFunction(w, r, id)
 	if err != nil {
-		a.sendInvalidInputResponse(w, err)
+		var overlapError *projects.OverlapError
+		switch {
+		case errors.As(err, &overlapError):
+			a.sendConflictResponse(w, overlapError)
+		default:
+			a.sendInvalidInputResponse(w, err)
+		}
 	}
 }
 
+func (a *apiImpl) sendConflictResponse(w http.ResponseWriter, err *projects.OverlapError) {
+	a.logger.Error(err.Error())
+
+	w.WriteHeader(http.StatusConflict)
+
+	jsonResponse, _ := json.Marshal(
+		map[string]any{
+			"error":      "Conflict",
+			"message":    err.Error(),
+			"activities": err.Activities,
+		})
+	w.Write(jsonResponse)
+}
+
 func (a *apiImpl) sendInvalidInputResponse(w http.ResponseWriter, err error) {
 	a.logger.Error(err.Error())
 
@@ -124,9 +147,10 @@ func (a *apiImpl) handleNoAction(w http.ResponseWriter, r *http.Request, id int)
 		w.Write(jsonResponse)
 	case http.MethodPost:
 		type changeActivity struct {
-			ProjectName string     `json:"projectName"`
-			StartedAt   time.Time  `json:"startedAt"`
-			EndedAt     *time.Time `json:"endedAt"`
+			ProjectName    string     `json:"projectName"`
+			StartedAt      time.Time  `json:"startedAt"`
+			EndedAt        *time.Time `json:"endedAt"`
+			TrimNeighbours bool       `json:"trimNeighbours"`
 		}
 
 		var changeData changeActivity
@@ -151,7 +175,65 @@ func (a *apiImpl) handleNoAction(w http.ResponseWriter, r *http.Request, id int)
 			ProjectName: changeData.ProjectName,
 			StartedAt:   changeData.StartedAt,
 			EndedAt:     changeData.EndedAt,
-		}); err != nil {
+		}, changeData.TrimNeighbours); err != nil {
+			return err
+		}
+
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+
+	return nil
+}
+
+func (a *apiImpl) handleDiscardIdleAction(w http.ResponseWriter, r *http.Request, id int) error {
+	switch r.Method {
+	case http.MethodPost:
+		if err := a.activityHandler.DiscardIdle(r.Context(), id); err != nil {
+			return err
+		}
+
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+
+	return nil
+}
+
+func (a *apiImpl) handleSplitAction(w http.ResponseWriter, r *http.Request, id int) error {
+	switch r.Method {
+	case http.MethodPost:
+		type splitActivity struct {
+			SplitAt     time.Time `json:"splitAt"`
+			ProjectName string    `json:"projectName"`
+		}
+
+		var splitData splitActivity
+		if err := json.NewDecoder(r.Body).Decode(&splitData); err != nil {
+			return errors.New("error parsing parameters")
+		}
+
+		secondID, err := a.activityHandler.SplitActivity(r.Context(), id, splitData.SplitAt, splitData.ProjectName)
+		if err != nil {
+			return err
+		}
+
+		w.WriteHeader(http.StatusOK)
+		jsonResponse, _ := json.Marshal(map[string]int{"id": secondID})
+		w.Write(jsonResponse)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+
+	return nil
+}
+
+func (a *apiImpl) handleMergeAction(w http.ResponseWriter, r *http.Request, id int) error {
+	switch r.Method {
+	case http.MethodPost:
+		if err := a.activityHandler.MergeActivity(r.Context(), id); err != nil {
 			return err
 		}
 
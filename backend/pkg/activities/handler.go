@@ -10,11 +10,12 @@ import (
 	"github.com/DominikKuenkele/TimeTrack/projects"
 )
 
-const workHours = 8 * 60 * 60
-
 type Handler interface {
 	GetDailyActivities(ctx context.Context, day time.Time) (projects.DailyActivities, error)
-	ChangeActivity(ctx context.Context, activity projects.Activity) error
+	ChangeActivity(ctx context.Context, activity projects.Activity, trimNeighbours bool) error
+	DiscardIdle(ctx context.Context, id int) error
+	SplitActivity(ctx context.Context, id int, splitAt time.Time, projectName string) (int, error)
+	MergeActivity(ctx context.Context, id int) error
 }
 
 type handlerImpl struct {
@@ -43,26 +44,45 @@ func (h *handlerImpl) GetDailyActivities(ctx context.Context, day time.Time) (pr
 		return projects.DailyActivities{}, err
 	}
 
-	worktime, err := h.repository.GetWorktime(userID)
+	overtime, err := h.repository.GetOvertime(userID)
 	if err != nil {
 		return projects.DailyActivities{}, err
 	}
 
-	var overtime int64
-	for _, day := range worktime {
-		overtime += int64(day.Worktime-day.Breaktime) - workHours
+	location, err := h.repository.GetLocation(userID)
+	if err != nil {
+		return projects.DailyActivities{}, err
 	}
 
 	res := projects.DailyActivities{
 		Activities: activites,
 		Overtime:   overtime,
 	}
-	res.CalculateBreaktime()
-	res.CalculateWorktime()
+	res.CalculateForDay(projects.DayBounds(day, location))
 
 	return res, nil
 }
 
-func (h *handlerImpl) ChangeActivity(ctx context.Context, activity projects.Activity) error {
-	return h.repository.ChangeActivity(user.FromContext(ctx), activity)
+// ChangeActivity changes the activity. If trimNeighbours is set, overlapping
+// activities are shortened instead of rejecting the change.
+func (h *handlerImpl) ChangeActivity(ctx context.Context, activity projects.Activity, trimNeighbours bool) error {
+	return h.repository.ChangeActivity(user.FromContext(ctx), activity, trimNeighbours)
+}
+
+func (h *handlerImpl) DiscardIdle(ctx context.Context, id int) error {
+	return h.repository.DiscardIdle(user.FromContext(ctx), id)
+}
+
+// SplitActivity cuts the activity at splitAt into two and returns the id of the
+// second part. If projectName is set, the second part is reassigned to it.
+func (h *handlerImpl) SplitActivity(ctx context.Context, id int, splitAt time.Time, projectName string) (int, error) {
+	if splitAt.IsZero() {
+		return 0, errors.New("splitAt must be set")
+	}
+
+	return h.repository.SplitActivity(user.FromContext(ctx), id, splitAt, projectName)
+}
+
+func (h *handlerImpl) MergeActivity(ctx context.Context, id int) error {
+	return h.repository.MergeActivity(user.FromContext(ctx), id)
 }
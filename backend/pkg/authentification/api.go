@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,26 +17,39 @@ import (
 
 const Prefix = "/user"
 
+// AdminPrefix is the prefix of the API for managing users, which is only
+// available to admins.
+const AdminPrefix = "/admin"
+
 const sessionCookieKey = "session"
 
+// oidcStateCookieKey binds the OIDC login to the browser, which started it.
+const oidcStateCookieKey = "oidc_state"
+
 type API interface {
 	HTTPHandler(w http.ResponseWriter, r *http.Request)
+	AdminHTTPHandler(w http.ResponseWriter, r *http.Request)
 	Authenticate(next http.HandlerFunc) http.HandlerFunc
+	RequireRole(role Role, next http.HandlerFunc) http.HandlerFunc
 }
 
 type apiImpl struct {
 	logger                  logger.Logger
 	authentificationHandler Handler
 	enableCreateUser        bool
+	trustProxyHeaders       bool
+	frontendAddress         string
 }
 
 var _ API = &apiImpl{}
 
-func NewAPI(logger logger.Logger, authentificationHandler Handler, enableCreateUser bool) API {
+func NewAPI(logger logger.Logger, authentificationHandler Handler, enableCreateUser, trustProxyHeaders bool, frontendAddress string) API {
 	return &apiImpl{
 		logger:                  logger,
 		authentificationHandler: authentificationHandler,
 		enableCreateUser:        enableCreateUser,
+		trustProxyHeaders:       trustProxyHeaders,
+		frontendAddress:         frontendAddress,
 	}
 }
 
@@ -42,15 +57,38 @@ type actionFunc func(w http.ResponseWriter, r *http.Request) error
 
 func (a *apiImpl) HTTPHandler(w http.ResponseWriter, r *http.Request) {
 	actionMap := map[string]actionFunc{
-		"login":    a.handleLoginAction,
-		"logout":   a.handleLogoutAction,
-		"validate": a.handleValidateAction,
+		"login":      a.handleLoginAction,
+		"logout":     a.handleLogoutAction,
+		"validate":   a.handleValidateAction,
+		"settings":   a.requireUser(a.handleSettingsAction),
+		"tokens":     a.requireUser(a.handleTokensAction),
+		"password":   a.requireUser(a.handlePasswordAction),
+		"reset":      a.handleResetAction,
+		"totp":       a.requireUser(a.handleTOTPAction),
+		"sessions":   a.requireUser(a.handleSessionsAction),
+		"oidc":       a.handleOIDCAction,
+		"identities": a.requireUser(a.handleIdentitiesAction),
 	}
 
 	if a.enableCreateUser {
 		actionMap["create"] = a.handleCreateAction
 	}
 
+	a.dispatch(w, r, actionMap)
+}
+
+// AdminHTTPHandler serves the API for managing users. It must be wrapped by
+// RequireRole.
+func (a *apiImpl) AdminHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	actionMap := map[string]actionFunc{
+		"users": a.handleUsersAction,
+	}
+
+	a.dispatch(w, r, actionMap)
+}
+
+// dispatch runs the action of the second path segment.
+func (a *apiImpl) dispatch(w http.ResponseWriter, r *http.Request, actionMap map[string]actionFunc) {
 	w.Header().Set("Content-Type", "application/json")
 
 	pathSegments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
@@ -75,8 +113,58 @@ func (a *apiImpl) HTTPHandler(w http.ResponseWriter, r *http.Request) {
 
 	err = actionFunction(w, r)
 	if err != nil {
-		a.sendInvalidInputResponse(w, err)
+		var lockedError *LoginLockedError
+		switch {
+		case errors.As(err, &lockedError):
+			a.sendTooManyRequestsResponse(w, lockedError)
+		default:
+			a.sendInvalidInputResponse(w, err)
+		}
+	}
+}
+
+func (a *apiImpl) sendTooManyRequestsResponse(w http.ResponseWriter, err *LoginLockedError) {
+	a.logger.Error(err.Error())
+
+	retryAfter := max(int(time.Until(err.LockedUntil).Seconds())+1, 1)
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	w.WriteHeader(http.StatusTooManyRequests)
+
+	jsonResponse, _ := json.Marshal(
+		map[string]string{
+			"error":   "Too Many Requests",
+			"message": err.Error(),
+		})
+	w.Write(jsonResponse)
+}
+
+func (a *apiImpl) client(r *http.Request) Client {
+	return Client{
+		UserAgent: r.UserAgent(),
+		IP:        a.clientIP(r),
+	}
+}
+
+// clientIP returns the IP of the client, which is taken from the
+// X-Forwarded-For header, if proxy headers are trusted. Only the rightmost
+// entry is added by the reverse proxy, all others are sent by the client and
+// can be spoofed.
+func (a *apiImpl) clientIP(r *http.Request) string {
+	if a.trustProxyHeaders {
+		if forwardedFor := r.Header.Values("X-Forwarded-For"); len(forwardedFor) > 0 {
+			entries := strings.Split(forwardedFor[len(forwardedFor)-1], ",")
+			if ip := strings.TrimSpace(entries[len(entries)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
 	}
+
+	return ip
 }
 
 func (a *apiImpl) sendInvalidInputResponse(w http.ResponseWriter, err error) {
@@ -93,11 +181,17 @@ func (a *apiImpl) sendInvalidInputResponse(w http.ResponseWriter, err error) {
 }
 
 func (a *apiImpl) handleLoginAction(w http.ResponseWriter, r *http.Request) error {
+	pathSegments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathSegments) > 2 && pathSegments[2] == "totp" {
+		return a.handleLoginTOTPAction(w, r)
+	}
+
 	switch r.Method {
 	case http.MethodPost:
 		type loginForm struct {
-			Username string `json:"username"`
-			Password string `json:"password"`
+			Username   string `json:"username"`
+			Password   string `json:"password"`
+			RememberMe bool   `json:"rememberMe"`
 		}
 
 		var loginData loginForm
@@ -113,7 +207,53 @@ func (a *apiImpl) handleLoginAction(w http.ResponseWriter, r *http.Request) erro
 			return errors.New("password must be present")
 		}
 
-		sessionID, expiry, err := a.authentificationHandler.Login(loginData.Username, loginData.Password)
+		sessionID, expiry, challenge, err := a.authentificationHandler.Login(loginData.Username, loginData.Password, a.client(r), loginData.RememberMe)
+		if err != nil {
+			return err
+		}
+
+		// the session is only created after the second factor
+		if challenge != nil {
+			w.WriteHeader(http.StatusOK)
+			jsonResponse, _ := json.Marshal(challenge)
+			w.Write(jsonResponse)
+			return nil
+		}
+
+		setSessionCookie(w, sessionID, expiry)
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+
+	return nil
+}
+
+// handleLoginTOTPAction completes the login of /user/login with the code of
+// the authenticator app or a recovery code.
+func (a *apiImpl) handleLoginTOTPAction(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case http.MethodPost:
+		type totpForm struct {
+			Challenge  string `json:"challenge"`
+			Code       string `json:"code"`
+			RememberMe bool   `json:"rememberMe"`
+		}
+
+		var totpData totpForm
+		if err := json.NewDecoder(r.Body).Decode(&totpData); err != nil {
+			return errors.New("error parsing parameters")
+		}
+
+		if totpData.Challenge == "" {
+			return errors.New("challenge must be present")
+		}
+
+		if totpData.Code == "" {
+			return errors.New("code must be present")
+		}
+
+		sessionID, expiry, err := a.authentificationHandler.CompleteLogin(totpData.Challenge, totpData.Code, a.client(r), totpData.RememberMe)
 		if err != nil {
 			return err
 		}
@@ -170,7 +310,7 @@ func (a *apiImpl) handleCreateAction(w http.ResponseWriter, r *http.Request) err
 			return errors.New("password must be present")
 		}
 
-		sessionID, expiry, err := a.authentificationHandler.CreateUser(createData.Username, createData.Password)
+		sessionID, expiry, err := a.authentificationHandler.CreateUser(createData.Username, createData.Password, a.client(r))
 		if err != nil {
 			return err
 		}
@@ -205,6 +345,539 @@ func (a *apiImpl) handleValidateAction(w http.ResponseWriter, r *http.Request) e
 	return nil
 }
 
+func (a *apiImpl) handleSettingsAction(w http.ResponseWriter, r *http.Request) error {
+	userID := user.FromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		settings, err := a.authentificationHandler.GetSettings(userID)
+		if err != nil {
+			return err
+		}
+
+		w.WriteHeader(http.StatusOK)
+		jsonResponse, _ := json.Marshal(settings)
+		w.Write(jsonResponse)
+	case http.MethodPost:
+		settings, err := a.authentificationHandler.GetSettings(userID)
+		if err != nil {
+			return err
+		}
+
+		// only the fields present in the body are overwritten
+		if err := json.NewDecoder(r.Body).Decode(settings); err != nil {
+			return errors.New("error parsing parameters")
+		}
+
+		if err := a.authentificationHandler.UpdateSettings(userID, settings); err != nil {
+			return err
+		}
+
+		w.WriteHeader(http.StatusOK)
+		jsonResponse, _ := json.Marshal(settings)
+		w.Write(jsonResponse)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+
+	return nil
+}
+
+func (a *apiImpl) handlePasswordAction(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case http.MethodPost:
+		type changePassword struct {
+			OldPassword string `json:"oldPassword"`
+			NewPassword string `json:"newPassword"`
+		}
+
+		var passwordData changePassword
+		if err := json.NewDecoder(r.Body).Decode(&passwordData); err != nil {
+			return errors.New("error parsing parameters")
+		}
+
+		// the current session stays logged in
+		var sessionID string
+		if sessionCookie, _ := r.Cookie(sessionCookieKey); sessionCookie != nil {
+			sessionID = sessionCookie.Value
+		}
+
+		if err := a.authentificationHandler.ChangePassword(user.FromContext(r.Context()), sessionID, passwordData.OldPassword, passwordData.NewPassword, a.client(r)); err != nil {
+			return err
+		}
+
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+
+	return nil
+}
+
+// handleResetAction redeems a reset token, which was issued by an admin via
+// /admin/users/{id}/reset. The user isn't logged in.
+func (a *apiImpl) handleResetAction(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case http.MethodPut:
+		type redeemReset struct {
+			Token       string `json:"token"`
+			NewPassword string `json:"newPassword"`
+		}
+
+		var resetData redeemReset
+		if err := json.NewDecoder(r.Body).Decode(&resetData); err != nil {
+			return errors.New("error parsing parameters")
+		}
+
+		if resetData.Token == "" {
+			return errors.New("token must be present")
+		}
+
+		if err := a.authentificationHandler.ResetPassword(resetData.Token, resetData.NewPassword); err != nil {
+			return err
+		}
+
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+
+	return nil
+}
+
+// handleOIDCAction redirects to the identity provider for logging in
+// (/user/oidc/login) or linking an identity to the current user
+// (/user/oidc/link) and creates the session on its callback
+// (/user/oidc/callback). Afterwards the user is redirected to the frontend.
+func (a *apiImpl) handleOIDCAction(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return nil
+	}
+
+	pathSegments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathSegments) <= 2 {
+		return errors.New("OIDC step must be present")
+	}
+
+	switch pathSegments[2] {
+	case "login":
+		authURL, state, err := a.authentificationHandler.StartOIDCLogin(r.URL.Query().Get("rememberMe") == "true")
+		if err != nil {
+			return err
+		}
+
+		setOIDCStateCookie(w, state, int(oidcLoginLength.Seconds()))
+		http.Redirect(w, r, authURL, http.StatusFound)
+	case "link":
+		return a.requireUser(func(w http.ResponseWriter, r *http.Request) error {
+			authURL, state, err := a.authentificationHandler.StartOIDCLink(user.FromContext(r.Context()))
+			if err != nil {
+				return err
+			}
+
+			setOIDCStateCookie(w, state, int(oidcLoginLength.Seconds()))
+			http.Redirect(w, r, authURL, http.StatusFound)
+
+			return nil
+		})(w, r)
+	case "callback":
+		query := r.URL.Query()
+		setOIDCStateCookie(w, "", -1)
+
+		if errorCode := query.Get("error"); errorCode != "" {
+			a.redirectLoginError(w, r, fmt.Errorf("identity provider returned '%s'", errorCode))
+			return nil
+		}
+
+		stateCookie, err := r.Cookie(oidcStateCookieKey)
+		if err != nil || stateCookie.Value != query.Get("state") {
+			a.redirectLoginError(w, r, errors.New("invalid OIDC login state"))
+			return nil
+		}
+
+		sessionID, expiry, err := a.authentificationHandler.CompleteOIDCLogin(query.Get("state"), query.Get("code"), a.client(r))
+		if err != nil {
+			a.redirectLoginError(w, r, err)
+			return nil
+		}
+
+		setSessionCookie(w, sessionID, expiry)
+		http.Redirect(w, r, a.frontendAddress+"/", http.StatusFound)
+	default:
+		return fmt.Errorf("OIDC step '%s' not supported", pathSegments[2])
+	}
+
+	return nil
+}
+
+// redirectLoginError redirects to the login page of the frontend, which shows
+// the error.
+func (a *apiImpl) redirectLoginError(w http.ResponseWriter, r *http.Request, err error) {
+	a.logger.Error("OIDC login failed: %s", err.Error())
+
+	http.Redirect(w, r, a.frontendAddress+"/auth/login?error="+url.QueryEscape(err.Error()), http.StatusFound)
+}
+
+// handleIdentitiesAction lists the linked OIDC identities of the user (GET)
+// and unlinks the identity /user/identities/{id} (DELETE).
+func (a *apiImpl) handleIdentitiesAction(w http.ResponseWriter, r *http.Request) error {
+	userID := user.FromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		identities, err := a.authentificationHandler.GetIdentities(userID)
+		if err != nil {
+			return err
+		}
+
+		w.WriteHeader(http.StatusOK)
+		jsonResponse, _ := json.Marshal(identities)
+		w.Write(jsonResponse)
+	case http.MethodDelete:
+		identityID, err := pathID(r, 2)
+		if err != nil {
+			return err
+		}
+
+		if err := a.authentificationHandler.UnlinkIdentity(userID, identityID); err != nil {
+			return err
+		}
+
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+
+	return nil
+}
+
+// handleTOTPAction enrols a new secret (POST), enables it with the first code
+// (PUT) and disables two-factor authentication with the password (DELETE).
+func (a *apiImpl) handleTOTPAction(w http.ResponseWriter, r *http.Request) error {
+	userID := user.FromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodPost:
+		enrolment, err := a.authentificationHandler.EnrolTOTP(userID)
+		if err != nil {
+			return err
+		}
+
+		w.WriteHeader(http.StatusOK)
+		jsonResponse, _ := json.Marshal(enrolment)
+		w.Write(jsonResponse)
+	case http.MethodPut:
+		type confirmTOTP struct {
+			Code string `json:"code"`
+		}
+
+		var totpData confirmTOTP
+		if err := json.NewDecoder(r.Body).Decode(&totpData); err != nil {
+			return errors.New("error parsing parameters")
+		}
+
+		recoveryCodes, err := a.authentificationHandler.ConfirmTOTP(userID, totpData.Code)
+		if err != nil {
+			return err
+		}
+
+		w.WriteHeader(http.StatusOK)
+		jsonResponse, _ := json.Marshal(map[string][]string{
+			"recoveryCodes": recoveryCodes,
+		})
+		w.Write(jsonResponse)
+	case http.MethodDelete:
+		type disableTOTP struct {
+			Password string `json:"password"`
+		}
+
+		var totpData disableTOTP
+		if err := json.NewDecoder(r.Body).Decode(&totpData); err != nil {
+			return errors.New("error parsing parameters")
+		}
+
+		if err := a.authentificationHandler.DisableTOTP(userID, totpData.Password); err != nil {
+			return err
+		}
+
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+
+	return nil
+}
+
+// handleSessionsAction lists the sessions of the user (GET) and revokes the
+// session /user/sessions/{id} or, without id, all other sessions (DELETE).
+func (a *apiImpl) handleSessionsAction(w http.ResponseWriter, r *http.Request) error {
+	userID := user.FromContext(r.Context())
+
+	var sessionID string
+	if sessionCookie, _ := r.Cookie(sessionCookieKey); sessionCookie != nil {
+		sessionID = sessionCookie.Value
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		sessions, err := a.authentificationHandler.GetSessions(userID, sessionID)
+		if err != nil {
+			return err
+		}
+
+		w.WriteHeader(http.StatusOK)
+		jsonResponse, _ := json.Marshal(sessions)
+		w.Write(jsonResponse)
+	case http.MethodDelete:
+		pathSegments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if len(pathSegments) <= 2 {
+			if err := a.authentificationHandler.RevokeOtherSessions(userID, sessionID); err != nil {
+				return err
+			}
+
+			w.WriteHeader(http.StatusOK)
+			return nil
+		}
+
+		publicID, err := pathID(r, 2)
+		if err != nil {
+			return err
+		}
+
+		if err := a.authentificationHandler.RevokeSession(userID, publicID); err != nil {
+			return err
+		}
+
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+
+	return nil
+}
+
+func (a *apiImpl) handleTokensAction(w http.ResponseWriter, r *http.Request) error {
+	userID := user.FromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		tokens, err := a.authentificationHandler.GetAccessTokens(userID)
+		if err != nil {
+			return err
+		}
+
+		w.WriteHeader(http.StatusOK)
+		jsonResponse, _ := json.Marshal(tokens)
+		w.Write(jsonResponse)
+	case http.MethodPost:
+		type createToken struct {
+			Name      string     `json:"name"`
+			Scopes    []Scope    `json:"scopes"`
+			ExpiresAt *time.Time `json:"expiresAt"`
+		}
+
+		var tokenData createToken
+		if err := json.NewDecoder(r.Body).Decode(&tokenData); err != nil {
+			return errors.New("error parsing parameters")
+		}
+
+		token, err := a.authentificationHandler.CreateAccessToken(userID, tokenData.Name, tokenData.Scopes, tokenData.ExpiresAt)
+		if err != nil {
+			return err
+		}
+
+		w.WriteHeader(http.StatusOK)
+		jsonResponse, _ := json.Marshal(token)
+		w.Write(jsonResponse)
+	case http.MethodDelete:
+		tokenID, err := pathID(r, 2)
+		if err != nil {
+			return err
+		}
+
+		if err := a.authentificationHandler.RevokeAccessToken(userID, tokenID); err != nil {
+			return err
+		}
+
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+
+	return nil
+}
+
+// pathID parses the id in the path segment at index, e.g. the token id in
+// /user/tokens/{id}.
+func pathID(r *http.Request, index int) (int, error) {
+	pathSegments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathSegments) <= index {
+		return 0, errors.New("id must be present")
+	}
+
+	id, err := strconv.Atoi(pathSegments[index])
+	if err != nil {
+		return 0, fmt.Errorf("couldn't parse id '%s'", pathSegments[index])
+	}
+
+	return id, nil
+}
+
+// handleUsersAction lists (GET) and creates (POST) users at /admin/users.
+// /admin/users/{id} is updated (PUT) or deleted (DELETE), a password reset is
+// issued via /admin/users/{id}/reset (POST) and the statistics are shown via
+// /admin/users/{id}/usage (GET).
+func (a *apiImpl) handleUsersAction(w http.ResponseWriter, r *http.Request) error {
+	adminID := user.FromContext(r.Context())
+
+	pathSegments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathSegments) <= 2 {
+		switch r.Method {
+		case http.MethodGet:
+			users, err := a.authentificationHandler.GetUsers()
+			if err != nil {
+				return err
+			}
+
+			w.WriteHeader(http.StatusOK)
+			jsonResponse, _ := json.Marshal(users)
+			w.Write(jsonResponse)
+		case http.MethodPost:
+			type createUser struct {
+				Username string `json:"username"`
+				Password string `json:"password"`
+				Role     Role   `json:"role"`
+			}
+
+			userData := createUser{Role: RoleMember}
+			if err := json.NewDecoder(r.Body).Decode(&userData); err != nil {
+				return errors.New("error parsing parameters")
+			}
+
+			createdUser, err := a.authentificationHandler.AddUser(userData.Username, userData.Password, userData.Role)
+			if err != nil {
+				return err
+			}
+
+			w.WriteHeader(http.StatusOK)
+			jsonResponse, _ := json.Marshal(createdUser)
+			w.Write(jsonResponse)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+
+		return nil
+	}
+
+	userID, err := url.PathUnescape(pathSegments[2])
+	if err != nil {
+		return fmt.Errorf("couldn't parse user '%s'", pathSegments[2])
+	}
+
+	var subAction string
+	if len(pathSegments) > 3 {
+		subAction = pathSegments[3]
+	}
+
+	switch subAction {
+	case "":
+		switch r.Method {
+		case http.MethodPut:
+			type updateUser struct {
+				Role     *Role `json:"role"`
+				Disabled *bool `json:"disabled"`
+			}
+
+			var userData updateUser
+			if err := json.NewDecoder(r.Body).Decode(&userData); err != nil {
+				return errors.New("error parsing parameters")
+			}
+
+			updatedUser, err := a.authentificationHandler.UpdateUser(adminID, userID, userData.Role, userData.Disabled)
+			if err != nil {
+				return err
+			}
+
+			w.WriteHeader(http.StatusOK)
+			jsonResponse, _ := json.Marshal(updatedUser)
+			w.Write(jsonResponse)
+		case http.MethodDelete:
+			if err := a.authentificationHandler.DeleteUser(adminID, userID); err != nil {
+				return err
+			}
+
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	case "reset":
+		switch r.Method {
+		case http.MethodPost:
+			token, expiry, err := a.authentificationHandler.IssuePasswordReset(userID)
+			if err != nil {
+				return err
+			}
+
+			a.logger.Info("Admin '%s' issued a password reset for user '%s'", adminID, userID)
+
+			w.WriteHeader(http.StatusOK)
+			jsonResponse, _ := json.Marshal(map[string]any{
+				"token":     token,
+				"expiresAt": expiry,
+			})
+			w.Write(jsonResponse)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	case "usage":
+		switch r.Method {
+		case http.MethodGet:
+			usage, err := a.authentificationHandler.GetUsage(userID)
+			if err != nil {
+				return err
+			}
+
+			w.WriteHeader(http.StatusOK)
+			jsonResponse, _ := json.Marshal(usage)
+			w.Write(jsonResponse)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	default:
+		return fmt.Errorf("action '%s' not supported", subAction)
+	}
+
+	return nil
+}
+
+// RequireRole wraps a handler, so that it is only executed for users with the
+// role. It must be wrapped by Authenticate, which provides the user.
+func (a *apiImpl) RequireRole(role Role, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userRole, err := a.authentificationHandler.GetRole(user.FromContext(r.Context()))
+		if err != nil || !userRole.includes(role) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireUser wraps an action, so that it is only executed for authenticated
+// users, which are made available via the request context.
+func (a *apiImpl) requireUser(action actionFunc) actionFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		var actionErr error
+		a.Authenticate(func(w http.ResponseWriter, r *http.Request) {
+			actionErr = action(w, r)
+		})(w, r)
+
+		return actionErr
+	}
+}
+
 func (a *apiImpl) Authenticate(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if sessionCookie, err := r.Cookie(sessionCookieKey); err == nil && sessionCookie != nil {
@@ -215,8 +888,20 @@ func (a *apiImpl) Authenticate(next http.HandlerFunc) http.HandlerFunc {
 			}
 		}
 
-		if token := extractBearerToken(r); token != "" {
-			sessionID, expiry, err := a.authentificationHandler.ValidateOAuthToken(token)
+		if token := extractBearerToken(r); strings.HasPrefix(token, accessTokenPrefix) {
+			accessToken, err := a.authentificationHandler.ValidateAccessToken(token)
+			if err == nil {
+				if !accessToken.allows(r) {
+					http.Error(w, "Forbidden", http.StatusForbidden)
+					return
+				}
+
+				ctx := user.ToContext(r.Context(), accessToken.UserID)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+		} else if token != "" {
+			sessionID, expiry, err := a.authentificationHandler.ValidateOAuthToken(token, a.client(r))
 			if err == nil {
 				setSessionCookie(w, sessionID, expiry)
 
@@ -244,6 +929,20 @@ func setSessionCookie(w http.ResponseWriter, sessionID string, expiry time.Time)
 	})
 }
 
+// setOIDCStateCookie stores the state during the OIDC login. It is sent along
+// with the redirect of the identity provider, so SameSite must be lax.
+func setOIDCStateCookie(w http.ResponseWriter, state string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookieKey,
+		Value:    state,
+		Path:     Prefix + "/oidc",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
 func extractBearerToken(r *http.Request) string {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
@@ -0,0 +1,38 @@
+package authentification
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name              string
+		trustProxyHeaders bool
+		forwardedFor      []string
+		ip                string
+	}{
+		{"without proxy", false, nil, "192.0.2.1"},
+		{"untrusted header", false, []string{"198.51.100.7"}, "192.0.2.1"},
+		{"trusted header", true, []string{"198.51.100.7"}, "198.51.100.7"},
+		{"spoofed leading entry", true, []string{"203.0.113.9, 198.51.100.7"}, "198.51.100.7"},
+		{"spoofed leading header", true, []string{"203.0.113.9", "198.51.100.7"}, "198.51.100.7"},
+		{"trusted proxy without header", true, nil, "192.0.2.1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := &apiImpl{trustProxyHeaders: test.trustProxyHeaders}
+
+			r := httptest.NewRequest("POST", "/user/login", nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+			for _, forwardedFor := range test.forwardedFor {
+				r.Header.Add("X-Forwarded-For", forwardedFor)
+			}
+
+			if ip := api.clientIP(r); ip != test.ip {
+				t.Errorf("expected IP '%s', got '%s'", test.ip, ip)
+			}
+		})
+	}
+}
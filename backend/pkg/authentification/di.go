@@ -2,12 +2,48 @@ package authentification
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/DominikKuenkele/TimeTrack/libraries/database"
 	"github.com/DominikKuenkele/TimeTrack/libraries/logger"
+	"github.com/DominikKuenkele/TimeTrack/libraries/totp"
+	"github.com/DominikKuenkele/TimeTrack/projects"
 )
 
-func BuildAuthenticator(logger logger.Logger, database database.Database, enableCreateUser bool, oauthServerURL, oauthClientID string) (API, error) {
+type Config struct {
+	EnableCreateUser bool
+	OAuthServerURL   string
+	OAuthClientID    string
+	// OAuthClientSecret and OAuthRedirectURL enable the authorization code
+	// flow, in which the backend logs in users at the identity provider.
+	OAuthClientSecret string
+	OAuthRedirectURL  string
+	// OAuthAdminGroups are the groups of the identity provider, whose members
+	// get the admin role. If empty, roles aren't taken from the groups.
+	OAuthAdminGroups []string
+	// FrontendAddress is the target of redirects after OIDC logins.
+	FrontendAddress string
+	// AdminUsers are granted the admin role on startup, which bootstraps the
+	// first admins.
+	AdminUsers []string
+	// TrustProxyHeaders takes the IP of clients from the last entry of the
+	// X-Forwarded-For header, which must only be enabled behind a single
+	// reverse proxy.
+	TrustProxyHeaders bool
+	Sessions          SessionConfig
+}
+
+type SessionConfig struct {
+	// IdleTimeout is extended, whenever the session is used.
+	IdleTimeout time.Duration
+	// RememberMeTimeout replaces the idle timeout for sessions, which should
+	// be remembered.
+	RememberMeTimeout time.Duration
+	// MaxLifetime limits sessions regardless of their use.
+	MaxLifetime time.Duration
+}
+
+func BuildAuthenticator(logger logger.Logger, database database.Database, config Config) (API, error) {
 	sessionRepository, err := NewSessionRepository(logger, database)
 	if err != nil {
 		return nil, fmt.Errorf("error building authenticator. %+v", err)
@@ -18,12 +54,61 @@ func BuildAuthenticator(logger logger.Logger, database database.Database, enable
 		return nil, fmt.Errorf("error building authenticator. %+v", err)
 	}
 
-	authenticatorHandler, err := NewHandler(logger, sessionRepository, userRepository, oauthServerURL, oauthClientID)
+	tokenRepository, err := NewTokenRepository(logger, database)
+	if err != nil {
+		return nil, fmt.Errorf("error building authenticator. %+v", err)
+	}
+
+	loginAttemptRepository, err := NewLoginAttemptRepository(logger, database)
+	if err != nil {
+		return nil, fmt.Errorf("error building authenticator. %+v", err)
+	}
+
+	totpRepository, err := NewTOTPRepository(logger, database)
+	if err != nil {
+		return nil, fmt.Errorf("error building authenticator. %+v", err)
+	}
+
+	oidcRepository, err := NewOIDCRepository(logger, database)
+	if err != nil {
+		return nil, fmt.Errorf("error building authenticator. %+v", err)
+	}
+
+	// the worktime of users is rebuilt, when they change their time zone
+	projectRepository, err := projects.NewRepository(logger, database)
 	if err != nil {
 		return nil, fmt.Errorf("error building authenticator. %+v", err)
 	}
 
-	api := NewAPI(logger, authenticatorHandler, enableCreateUser)
+	authenticatorHandler, err := NewHandler(
+		logger,
+		sessionRepository,
+		userRepository,
+		tokenRepository,
+		loginAttemptRepository,
+		totpRepository,
+		oidcRepository,
+		projectRepository,
+		totp.New(time.Now),
+		config.Sessions,
+		config.OAuthServerURL,
+		config.OAuthClientID,
+		config.OAuthClientSecret,
+		config.OAuthRedirectURL,
+		config.OAuthAdminGroups,
+		config.AdminUsers,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error building authenticator. %+v", err)
+	}
+
+	if len(config.AdminUsers) > 0 {
+		if err := userRepository.GrantRole(config.AdminUsers, RoleAdmin); err != nil {
+			return nil, fmt.Errorf("error building authenticator. %+v", err)
+		}
+	}
+
+	api := NewAPI(logger, authenticatorHandler, config.EnableCreateUser, config.TrustProxyHeaders, config.FrontendAddress)
 
 	return api, nil
 }
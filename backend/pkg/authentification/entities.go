@@ -0,0 +1,219 @@
+package authentification
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DominikKuenkele/TimeTrack/libraries/rounding"
+)
+
+type Settings struct {
+	TimeZone string `json:"timeZone"`
+	// MaxTimerDuration in seconds, after which running timers are stopped
+	// automatically. If nil, the default of the server is used.
+	MaxTimerDuration *int `json:"maxTimerDuration"`
+	// Rounding applies to all projects, which don't have their own rule. If
+	// nil, durations aren't rounded.
+	Rounding *rounding.Rule `json:"rounding"`
+}
+
+// OIDCLoginState is stored between redirecting the user to the identity
+// provider and the callback.
+type OIDCLoginState struct {
+	Nonce      string
+	Verifier   string
+	RememberMe bool
+	// LinkUserID is set, if the identity should be linked to an existing
+	// account instead of logging in.
+	LinkUserID string
+}
+
+// Identity is an OIDC identity, with which the user can log in.
+type Identity struct {
+	ID        int       `json:"id"`
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Profile holds the details of a user, which are taken from the claims of the
+// identity provider.
+type Profile struct {
+	Email       string
+	DisplayName string
+}
+
+type Role string
+
+const (
+	RoleAdmin  Role = "admin"
+	RoleMember Role = "member"
+)
+
+func (r Role) Valid() bool {
+	switch r {
+	case RoleAdmin, RoleMember:
+		return true
+	default:
+		return false
+	}
+}
+
+// includes checks whether the role grants everything the other role may do.
+func (r Role) includes(other Role) bool {
+	return r == other || r == RoleAdmin
+}
+
+// User is an account as listed for admins.
+type User struct {
+	ID          string    `json:"id"`
+	Email       string    `json:"email"`
+	DisplayName string    `json:"displayName"`
+	Role        Role      `json:"role"`
+	Disabled    bool      `json:"disabled"`
+	HasPassword bool      `json:"hasPassword"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Usage summarizes, how much a user tracked.
+type Usage struct {
+	Projects       int        `json:"projects"`
+	Activities     int        `json:"activities"`
+	TrackedSeconds int64      `json:"trackedSeconds"`
+	LastActivityAt *time.Time `json:"lastActivityAt"`
+	ActiveSessions int        `json:"activeSessions"`
+	AccessTokens   int        `json:"accessTokens"`
+}
+
+// Client describes where a request comes from. It is recorded with sessions,
+// so that users can recognize them.
+type Client struct {
+	UserAgent string
+	IP        string
+}
+
+// Session is an active login of a user. The ID is not the secret session ID
+// of the cookie, but only identifies the session for revoking it.
+type Session struct {
+	ID         int       `json:"id"`
+	UserAgent  string    `json:"userAgent"`
+	IP         string    `json:"ip"`
+	Current    bool      `json:"current"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// LoginLockedError is returned while logins are locked after too many failed
+// attempts. It doesn't reveal, whether the account exists.
+type LoginLockedError struct {
+	LockedUntil time.Time
+}
+
+func (e *LoginLockedError) Error() string {
+	return "too many failed login attempts, try again later"
+}
+
+// LoginChallenge is returned by the login of users with two-factor
+// authentication. The login is completed with the challenge and a code.
+type LoginChallenge struct {
+	Challenge string    `json:"challenge"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type TOTPSecret struct {
+	Secret    string
+	Confirmed bool
+	// LastUsedStep is the time step of the last accepted code, which can't be
+	// used again.
+	LastUsedStep *int64
+}
+
+// TOTPEnrolment holds the secret for setting up an authenticator app. The
+// provisioning URI is meant to be shown as QR code.
+type TOTPEnrolment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioningUri"`
+}
+
+type Scope string
+
+const (
+	// ScopeRead only allows reading data.
+	ScopeRead Scope = "read"
+	// ScopeTracking additionally allows tracking time, but not changing the
+	// account.
+	ScopeTracking Scope = "tracking"
+	// ScopeAdmin allows everything the user is allowed to do.
+	ScopeAdmin Scope = "admin"
+)
+
+func (s Scope) Valid() bool {
+	switch s {
+	case ScopeRead, ScopeTracking, ScopeAdmin:
+		return true
+	default:
+		return false
+	}
+}
+
+// credentialPaths manage the credentials of the user, which may only be read
+// or changed with the admin scope.
+var credentialPaths = []string{
+	Prefix + "/tokens",
+	Prefix + "/sessions",
+	Prefix + "/identities",
+	Prefix + "/oidc",
+	Prefix + "/totp",
+}
+
+// allows checks whether the request may be made with the scope.
+func (s Scope) allows(r *http.Request) bool {
+	switch {
+	case s == ScopeAdmin:
+		return true
+	case isCredentialPath(r.URL.Path):
+		return false
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return true
+	case s == ScopeTracking:
+		return !strings.HasPrefix(r.URL.Path, Prefix+"/") && !strings.HasPrefix(r.URL.Path, AdminPrefix+"/")
+	default:
+		return false
+	}
+}
+
+func isCredentialPath(path string) bool {
+	for _, credentialPath := range credentialPaths {
+		if path == credentialPath || strings.HasPrefix(path, credentialPath+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AccessToken is a personal access token, which authenticates scripts and CLI
+// clients. The token itself is only shown once on creation.
+type AccessToken struct {
+	ID         int        `json:"id"`
+	UserID     string     `json:"-"`
+	Name       string     `json:"name"`
+	Token      string     `json:"token,omitempty"`
+	Scopes     []Scope    `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+func (t *AccessToken) allows(r *http.Request) bool {
+	for _, scope := range t.Scopes {
+		if scope.allows(r) {
+			return true
+		}
+	}
+
+	return false
+}
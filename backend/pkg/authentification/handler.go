@@ -3,34 +3,113 @@ package authentification
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/DominikKuenkele/TimeTrack/libraries/logger"
+	"github.com/DominikKuenkele/TimeTrack/libraries/totp"
 	"github.com/coreos/go-oidc/v3/oidc"
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/oauth2"
 )
 
-const sessionLength = 24 * time.Hour
+// passwordResetLength is the time a password reset token can be redeemed.
+const passwordResetLength = time.Hour
+
+// dummyPasswordHash is compared against, if a user has no password, so that a
+// login takes the same time.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// Logins of an account or IP are locked after the threshold of consecutive
+// failed attempts was reached. The lockout doubles with every further failure.
+const (
+	accountLoginThreshold   = 5
+	ipLoginThreshold        = 20
+	loginLockoutBase        = 30 * time.Second
+	loginLockoutMax         = time.Hour
+	loginAttemptsResetAfter = 24 * time.Hour
+)
+
+// loginChallengeLength is the time, in which the second factor must be entered
+// after the password.
+const loginChallengeLength = 5 * time.Minute
+
+const (
+	totpIssuer        = "TimeTrack"
+	recoveryCodeCount = 10
+)
+
+// oidcLoginLength is the time, in which the login at the identity provider
+// must be completed.
+const oidcLoginLength = 10 * time.Minute
+
+// accessTokenPrefix distinguishes personal access tokens from OIDC tokens.
+const accessTokenPrefix = "tt_"
 
 type Handler interface {
-	CreateUser(username, password string) (string, time.Time, error)
-	Login(username, password string) (string, time.Time, error)
+	CreateUser(username, password string, client Client) (string, time.Time, error)
+	Login(username, password string, client Client, rememberMe bool) (string, time.Time, *LoginChallenge, error)
+	CompleteLogin(challenge, code string, client Client, rememberMe bool) (string, time.Time, error)
 	Logout(sessionID string) error
 	ValidateSession(sessionID string) (string, error)
-	ValidateOAuthToken(token string) (string, time.Time, error)
+	ValidateOAuthToken(token string, client Client) (string, time.Time, error)
+	StartOIDCLogin(rememberMe bool) (string, string, error)
+	StartOIDCLink(userID string) (string, string, error)
+	GetIdentities(userID string) ([]*Identity, error)
+	UnlinkIdentity(userID string, identityID int) error
+	GetRole(userID string) (Role, error)
+	GetUsers() ([]*User, error)
+	AddUser(username, password string, role Role) (*User, error)
+	UpdateUser(adminID, userID string, role *Role, disabled *bool) (*User, error)
+	DeleteUser(adminID, userID string) error
+	GetUsage(userID string) (*Usage, error)
+	CompleteOIDCLogin(state, code string, client Client) (string, time.Time, error)
+	GetSessions(userID, sessionID string) ([]*Session, error)
+	RevokeSession(userID string, sessionID int) error
+	RevokeOtherSessions(userID, sessionID string) error
+	GetSettings(userID string) (*Settings, error)
+	UpdateSettings(userID string, settings *Settings) error
+	CreateAccessToken(userID, name string, scopes []Scope, expiresAt *time.Time) (*AccessToken, error)
+	GetAccessTokens(userID string) ([]*AccessToken, error)
+	RevokeAccessToken(userID string, tokenID int) error
+	ValidateAccessToken(token string) (*AccessToken, error)
+	ChangePassword(userID, sessionID, oldPassword, newPassword string, client Client) error
+	IssuePasswordReset(userID string) (string, time.Time, error)
+	ResetPassword(token, newPassword string) error
+	EnrolTOTP(userID string) (*TOTPEnrolment, error)
+	ConfirmTOTP(userID, code string) ([]string, error)
+	DisableTOTP(userID, password string) error
+}
+
+// WorktimeRebuilder recalculates the worktime of a user, whose days changed
+// with the time zone.
+type WorktimeRebuilder interface {
+	RebuildAllWorktime(userID string) error
 }
 
 type handlerImpl struct {
-	logger            logger.Logger
-	sessionRepository SessionRepository
-	userRepository    UserRepository
-	oauthServerURL    string
-	provider          *oidc.Provider
-	verifier          *oidc.IDTokenVerifier
+	logger                 logger.Logger
+	sessionRepository      SessionRepository
+	userRepository         UserRepository
+	tokenRepository        TokenRepository
+	loginAttemptRepository LoginAttemptRepository
+	totpRepository         TOTPRepository
+	oidcRepository         OIDCRepository
+	worktimeRebuilder      WorktimeRebuilder
+	totp                   *totp.TOTP
+	sessionConfig          SessionConfig
+	oauthServerURL         string
+	adminGroups            []string
+	adminUsers             []string
+	oidc                   *oidcProvider
 }
 
 var _ Handler = &handlerImpl{}
@@ -39,30 +118,44 @@ func NewHandler(
 	l logger.Logger,
 	sessionRepository SessionRepository,
 	userRepository UserRepository,
+	tokenRepository TokenRepository,
+	loginAttemptRepository LoginAttemptRepository,
+	totpRepository TOTPRepository,
+	oidcRepository OIDCRepository,
+	worktimeRebuilder WorktimeRebuilder,
+	totpValidator *totp.TOTP,
+	sessionConfig SessionConfig,
 	oauthServerURL,
-	oauthClientID string,
+	oauthClientID,
+	oauthClientSecret,
+	oauthRedirectURL string,
+	adminGroups,
+	adminUsers []string,
 ) (Handler, error) {
-	ctx := context.Background()
-	provider, err := oidc.NewProvider(ctx, oauthServerURL)
+	provider, err := newOIDCProvider(l, oauthServerURL, oauthClientID, oauthClientSecret, oauthRedirectURL)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create OIDC provider: %w", err)
+		return nil, err
 	}
 
-	verifier := provider.Verifier(&oidc.Config{
-		ClientID: oauthClientID,
-	})
-
 	return &handlerImpl{
-		logger:            l,
-		sessionRepository: sessionRepository,
-		userRepository:    userRepository,
-		oauthServerURL:    oauthServerURL,
-		provider:          provider,
-		verifier:          verifier,
+		logger:                 l,
+		sessionRepository:      sessionRepository,
+		userRepository:         userRepository,
+		tokenRepository:        tokenRepository,
+		loginAttemptRepository: loginAttemptRepository,
+		totpRepository:         totpRepository,
+		oidcRepository:         oidcRepository,
+		worktimeRebuilder:      worktimeRebuilder,
+		totp:                   totpValidator,
+		sessionConfig:          sessionConfig,
+		oauthServerURL:         oauthServerURL,
+		adminGroups:            adminGroups,
+		adminUsers:             adminUsers,
+		oidc:                   provider,
 	}, nil
 }
 
-func (h *handlerImpl) CreateUser(userID, password string) (string, time.Time, error) {
+func (h *handlerImpl) CreateUser(userID, password string, client Client) (string, time.Time, error) {
 	if userID == "" {
 		return "", time.Time{}, errors.New("username must not be empty")
 	}
@@ -71,50 +164,246 @@ func (h *handlerImpl) CreateUser(userID, password string) (string, time.Time, er
 		return "", time.Time{}, errors.New("password must not be empty")
 	}
 
-	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	passwordHash, err := h.hashPassword(password)
 	if err != nil {
-		h.logger.Error("Error hashing password: %+v", err)
-		return "", time.Time{}, errors.New("couldn't save the password")
+		return "", time.Time{}, err
 	}
 
-	if err = h.userRepository.CreateUser(userID, string(passwordHash)); err != nil {
+	if err = h.userRepository.CreateUser(userID, passwordHash, RoleMember); err != nil {
 		// TODO user already exists?
 		return "", time.Time{}, errors.New("couldn't create user")
 	}
 
-	return h.createSession(userID)
+	return h.createSession(userID, client, false)
 }
 
-func (h *handlerImpl) Login(userID, password string) (string, time.Time, error) {
+// Login creates a session, if the password of the user is valid. The session
+// is remembered beyond the browser session, if rememberMe is set. Failed
+// attempts are tracked per account and per IP and lock further logins. Users
+// with two-factor authentication get a challenge instead of a session, which
+// is completed with CompleteLogin.
+func (h *handlerImpl) Login(userID, password string, client Client, rememberMe bool) (string, time.Time, *LoginChallenge, error) {
 	if userID == "" {
-		return "", time.Time{}, errors.New("username must not be empty")
+		return "", time.Time{}, nil, errors.New("username must not be empty")
+	}
+
+	if err := h.checkLoginLock(userID, client.IP); err != nil {
+		return "", time.Time{}, nil, err
 	}
 
 	storedHash, err := h.userRepository.GetPasswordHash(userID)
+	if err == nil {
+		err = bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(password))
+	} else {
+		// unknown users take as long as wrong passwords, so that the timing
+		// doesn't reveal which users exist
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+	}
 	if err != nil {
-		return "", time.Time{}, errors.New("invalid user/password")
+		if err := h.recordLoginFailure(userID, client.IP); err != nil {
+			return "", time.Time{}, nil, err
+		}
+
+		return "", time.Time{}, nil, errors.New("invalid user/password")
 	}
 
-	if err = bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(password)); err != nil {
-		return "", time.Time{}, errors.New("invalid user/password")
+	secret, err := h.totpRepository.GetSecret(userID)
+	if err != nil {
+		return "", time.Time{}, nil, err
+	}
+
+	if secret != nil && secret.Confirmed {
+		// the failures are only reset after the second factor, so that codes
+		// can't be guessed by logging in again
+		challenge, err := randomToken()
+		if err != nil {
+			return "", time.Time{}, nil, err
+		}
+
+		expiry := time.Now().Add(loginChallengeLength)
+		if err := h.totpRepository.CreateChallenge(hashToken(challenge), userID, expiry); err != nil {
+			return "", time.Time{}, nil, err
+		}
+
+		return "", time.Time{}, &LoginChallenge{Challenge: challenge, ExpiresAt: expiry}, nil
+	}
+
+	if err := h.loginAttemptRepository.ResetFailures(AttemptKindAccount, userID); err != nil {
+		return "", time.Time{}, nil, err
+	}
+
+	sessionID, expiry, err := h.createSession(userID, client, rememberMe)
+	return sessionID, expiry, nil, err
+}
+
+// CompleteLogin creates the session for a login challenge, if the code of the
+// authenticator app or a recovery code is valid. Invalid codes count as
+// failed logins.
+func (h *handlerImpl) CompleteLogin(challenge, code string, client Client, rememberMe bool) (string, time.Time, error) {
+	challengeHash := hashToken(challenge)
+	userID, err := h.totpRepository.GetChallengeUser(challengeHash)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	if err := h.checkLoginLock(userID, client.IP); err != nil {
+		return "", time.Time{}, err
+	}
+
+	if err := h.verifySecondFactor(userID, code); err != nil {
+		if err := h.recordLoginFailure(userID, client.IP); err != nil {
+			return "", time.Time{}, err
+		}
+
+		return "", time.Time{}, err
+	}
+
+	if err := h.totpRepository.DeleteChallenge(challengeHash); err != nil {
+		return "", time.Time{}, err
+	}
+
+	if err := h.loginAttemptRepository.ResetFailures(AttemptKindAccount, userID); err != nil {
+		return "", time.Time{}, err
 	}
 
-	return h.createSession(userID)
+	return h.createSession(userID, client, rememberMe)
 }
 
-func (h *handlerImpl) createSession(userID string) (string, time.Time, error) {
+// verifySecondFactor accepts a code of the authenticator app, which wasn't
+// used before, or an unused recovery code.
+func (h *handlerImpl) verifySecondFactor(userID, code string) error {
+	secret, err := h.totpRepository.GetSecret(userID)
+	if err != nil {
+		return err
+	}
+
+	if secret == nil || !secret.Confirmed {
+		return errors.New("two-factor authentication is not enabled")
+	}
+
+	code = strings.TrimSpace(code)
+	if step, err := h.totp.Validate(secret.Secret, code); err == nil {
+		return h.totpRepository.UseStep(userID, step)
+	}
+
+	return h.totpRepository.UseRecoveryCode(userID, hashToken(normalizeRecoveryCode(code)))
+}
+
+// checkLoginLock returns a LoginLockedError, if logins of the account or the
+// IP are locked. Unknown accounts are locked the same way as existing ones.
+func (h *handlerImpl) checkLoginLock(userID, ip string) error {
+	attempts := map[AttemptKind]string{
+		AttemptKindAccount: userID,
+		AttemptKindIP:      ip,
+	}
+
+	for kind, key := range attempts {
+		if key == "" {
+			continue
+		}
+
+		lockedUntil, err := h.loginAttemptRepository.GetLockedUntil(kind, key)
+		if err != nil {
+			return err
+		}
+
+		if lockedUntil != nil {
+			return &LoginLockedError{LockedUntil: *lockedUntil}
+		}
+	}
+
+	return nil
+}
+
+func (h *handlerImpl) recordLoginFailure(userID, ip string) error {
+	thresholds := map[AttemptKind]int{
+		AttemptKindAccount: accountLoginThreshold,
+		AttemptKindIP:      ipLoginThreshold,
+	}
+	attempts := map[AttemptKind]string{
+		AttemptKindAccount: userID,
+		AttemptKindIP:      ip,
+	}
+
+	for kind, key := range attempts {
+		if key == "" {
+			continue
+		}
+
+		failures, err := h.loginAttemptRepository.RecordFailure(kind, key, loginAttemptsResetAfter)
+		if err != nil {
+			return err
+		}
+
+		if failures < thresholds[kind] {
+			continue
+		}
+
+		lockout := loginLockoutMax
+		if exponent := failures - thresholds[kind]; exponent < 16 {
+			lockout = min(loginLockoutBase<<exponent, loginLockoutMax)
+		}
+
+		h.logger.Info("Locking logins of %s '%s' for %s after %d failed attempts", kind, key, lockout, failures)
+		if err := h.loginAttemptRepository.LockUntil(kind, key, time.Now().Add(lockout)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *handlerImpl) hashPassword(password string) (string, error) {
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		h.logger.Error("Error hashing password: %+v", err)
+		return "", errors.New("couldn't save the password")
+	}
+
+	return string(passwordHash), nil
+}
+
+// createSession creates a session, which expires after being idle for the
+// configured timeout and at the latest after the max lifetime. It returns the
+// expiry of the cookie, which is zero for a browser session, if the session
+// shouldn't be remembered.
+func (h *handlerImpl) createSession(userID string, client Client, rememberMe bool) (string, time.Time, error) {
+	user, err := h.userRepository.GetUser(userID)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	if user.Disabled {
+		return "", time.Time{}, errors.New("account is disabled")
+	}
+
 	b := make([]byte, 32)
 	if _, err := rand.Read(b); err != nil {
 		return "", time.Time{}, err
 	}
 	sessionID := base64.URLEncoding.EncodeToString(b)
 
-	expiry := time.Now().Add(sessionLength)
-	if err := h.sessionRepository.CreateSession(sessionID, userID, expiry); err != nil {
+	idleTimeout := h.sessionConfig.IdleTimeout
+	if rememberMe {
+		idleTimeout = h.sessionConfig.RememberMeTimeout
+	}
+
+	now := time.Now()
+	maxExpiry := now.Add(h.sessionConfig.MaxLifetime)
+	expiry := now.Add(idleTimeout)
+	if expiry.After(maxExpiry) {
+		expiry = maxExpiry
+	}
+
+	if err := h.sessionRepository.CreateSession(sessionID, userID, client, idleTimeout, expiry, maxExpiry); err != nil {
 		return "", time.Time{}, err
 	}
 
-	return sessionID, expiry, nil
+	if !rememberMe {
+		return sessionID, time.Time{}, nil
+	}
+
+	return sessionID, maxExpiry, nil
 }
 
 func (h *handlerImpl) Logout(sessionID string) error {
@@ -125,27 +414,119 @@ func (h *handlerImpl) ValidateSession(sessionID string) (string, error) {
 	return h.sessionRepository.GetSessionUser(sessionID)
 }
 
-func (h *handlerImpl) ValidateOAuthToken(token string) (string, time.Time, error) {
+func (h *handlerImpl) ValidateOAuthToken(token string, client Client) (string, time.Time, error) {
 	if token == "" {
 		return "", time.Time{}, errors.New("token must not be empty")
 	}
 
-	idToken, err := h.verifier.Verify(context.Background(), token)
+	verifier, err := h.oidc.get()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	idToken, err := verifier.Verify(context.Background(), token)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to verify token: %w", err)
+	}
+
+	return h.createOIDCSession(idToken, client, false, "")
+}
+
+// StartOIDCLogin starts the authorization code flow with PKCE. It returns the
+// URL of the identity provider, to which the user is redirected, and the
+// state, which must be passed to CompleteOIDCLogin.
+func (h *handlerImpl) StartOIDCLogin(rememberMe bool) (string, string, error) {
+	return h.startOIDC(&OIDCLoginState{RememberMe: rememberMe})
+}
+
+// StartOIDCLink starts the authorization code flow for linking an identity to
+// the user, who afterwards can log in with it.
+func (h *handlerImpl) StartOIDCLink(userID string) (string, string, error) {
+	return h.startOIDC(&OIDCLoginState{LinkUserID: userID})
+}
+
+func (h *handlerImpl) startOIDC(loginState *OIDCLoginState) (string, string, error) {
+	oauth2Config, _, err := h.oidc.getOAuth2Config()
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	loginState.Nonce, err = randomToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	loginState.Verifier = oauth2.GenerateVerifier()
+	expiry := time.Now().Add(oidcLoginLength)
+	if err := h.oidcRepository.CreateLoginState(hashToken(state), loginState, expiry); err != nil {
+		return "", "", err
+	}
+
+	authURL := oauth2Config.AuthCodeURL(state, oidc.Nonce(loginState.Nonce), oauth2.S256ChallengeOption(loginState.Verifier))
+
+	return authURL, state, nil
+}
+
+// CompleteOIDCLogin exchanges the authorization code for an ID token and
+// creates a session for it. If the login was started for linking, the identity
+// is linked to the user first. Each state can only be used once.
+func (h *handlerImpl) CompleteOIDCLogin(state, code string, client Client) (string, time.Time, error) {
+	if state == "" || code == "" {
+		return "", time.Time{}, errors.New("state and code must not be empty")
+	}
+
+	oauth2Config, verifier, err := h.oidc.getOAuth2Config()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	loginState, err := h.oidcRepository.ConsumeLoginState(hashToken(state))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	ctx := h.oidc.context()
+	token, err := oauth2Config.Exchange(ctx, code, oauth2.VerifierOption(loginState.Verifier))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to exchange code: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return "", time.Time{}, errors.New("identity provider didn't return an ID token")
+	}
+
+	idToken, err := verifier.Verify(ctx, rawIDToken)
 	if err != nil {
 		return "", time.Time{}, fmt.Errorf("failed to verify token: %w", err)
 	}
 
-	var claims struct {
-		Sub           string   `json:"sub"`
-		Email         string   `json:"email"`
-		EmailVerified bool     `json:"email_verified"`
-		Name          string   `json:"name"`
-		Groups        []string `json:"groups"`
-		IssuedAt      int64    `json:"iat"`
-		ExpiresAt     int64    `json:"exp"`
-		Issuer        string   `json:"iss"`
-		Audience      []string `json:"aud"`
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(loginState.Nonce)) != 1 {
+		return "", time.Time{}, errors.New("invalid token nonce")
 	}
+
+	return h.createOIDCSession(idToken, client, loginState.RememberMe, loginState.LinkUserID)
+}
+
+type oidcClaims struct {
+	Sub           string   `json:"sub"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+	Groups        []string `json:"groups"`
+	IssuedAt      int64    `json:"iat"`
+	ExpiresAt     int64    `json:"exp"`
+	Issuer        string   `json:"iss"`
+}
+
+// createOIDCSession creates a session for the user of the verified ID token.
+func (h *handlerImpl) createOIDCSession(idToken *oidc.IDToken, client Client, rememberMe bool, linkUserID string) (string, time.Time, error) {
+	var claims oidcClaims
 	if err := idToken.Claims(&claims); err != nil {
 		return "", time.Time{}, fmt.Errorf("failed to parse token claims: %w", err)
 	}
@@ -162,8 +543,507 @@ func (h *handlerImpl) ValidateOAuthToken(token string) (string, time.Time, error
 		return "", time.Time{}, errors.New("invalid token issuer")
 	}
 
-	h.logger.Info("User authenticated via OAuth: %s (email: %s, name: %s)",
-		claims.Sub, claims.Email, claims.Name)
+	userID, err := h.userForIdentity(&claims, linkUserID)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	h.logger.Info("User '%s' authenticated via OAuth: %s (email: %s, name: %s)",
+		userID, claims.Sub, claims.Email, claims.Name)
+
+	return h.createSession(userID, client, rememberMe)
+}
+
+// userForIdentity returns the user of the identity. Unknown identities are
+// linked to linkUserID or, without it, provisioned as new user with the
+// subject as ID. If admin groups are configured, the role of the user follows
+// the groups of the claims, but the configured admin users stay admins.
+func (h *handlerImpl) userForIdentity(claims *oidcClaims, linkUserID string) (string, error) {
+	userID, err := h.oidcRepository.GetIdentityUser(claims.Issuer, claims.Sub)
+	if err != nil {
+		return "", err
+	}
+
+	profile := Profile{
+		Email:       claims.Email,
+		DisplayName: claims.Name,
+	}
+	switch {
+	case linkUserID != "" && userID != "" && userID != linkUserID:
+		return "", errors.New("identity is already linked to another account")
+	case linkUserID != "" && userID == "":
+		if err := h.oidcRepository.LinkIdentity(linkUserID, claims.Issuer, claims.Sub, claims.Email); err != nil {
+			return "", err
+		}
+
+		h.logger.Info("Linked identity '%s' to user '%s'", claims.Sub, linkUserID)
+		userID = linkUserID
+	case userID == "":
+		role := h.roleForIdentity(claims.Sub, claims.Groups)
+		if err := h.oidcRepository.ProvisionUser(claims.Sub, profile, role, claims.Issuer, claims.Sub); err != nil {
+			return "", err
+		}
+
+		h.logger.Info("Provisioned user '%s' with role '%s'", claims.Sub, role)
+		return claims.Sub, nil
+	default:
+		if err := h.userRepository.UpdateProfile(userID, profile); err != nil {
+			return "", err
+		}
+	}
+
+	if len(h.adminGroups) > 0 {
+		if err := h.userRepository.UpdateRole(userID, h.roleForIdentity(userID, claims.Groups)); err != nil {
+			return "", err
+		}
+	}
+
+	return userID, nil
+}
+
+// roleForIdentity maps the groups of the identity provider to a role. The
+// configured admin users are never demoted.
+func (h *handlerImpl) roleForIdentity(userID string, groups []string) Role {
+	if slices.Contains(h.adminUsers, userID) {
+		return RoleAdmin
+	}
+
+	for _, group := range groups {
+		if slices.Contains(h.adminGroups, group) {
+			return RoleAdmin
+		}
+	}
+
+	return RoleMember
+}
+
+func (h *handlerImpl) GetIdentities(userID string) ([]*Identity, error) {
+	return h.oidcRepository.GetIdentities(userID)
+}
+
+// UnlinkIdentity removes the identity from the user. The last identity of a
+// user without password can't be removed, as the user couldn't log in anymore.
+func (h *handlerImpl) UnlinkIdentity(userID string, identityID int) error {
+	if _, err := h.userRepository.GetPasswordHash(userID); err != nil {
+		identities, err := h.oidcRepository.GetIdentities(userID)
+		if err != nil {
+			return err
+		}
+
+		if len(identities) <= 1 {
+			return errors.New("the only identity of a user without password can't be unlinked")
+		}
+	}
+
+	return h.oidcRepository.DeleteIdentity(userID, identityID)
+}
+
+// GetSessions returns the active sessions of the user. The session with
+// sessionID is marked as the current one.
+func (h *handlerImpl) GetSessions(userID, sessionID string) ([]*Session, error) {
+	return h.sessionRepository.GetUserSessions(userID, sessionID)
+}
+
+func (h *handlerImpl) RevokeSession(userID string, sessionID int) error {
+	return h.sessionRepository.DeleteUserSession(userID, sessionID)
+}
+
+// RevokeOtherSessions logs out all sessions of the user except sessionID.
+func (h *handlerImpl) RevokeOtherSessions(userID, sessionID string) error {
+	return h.sessionRepository.DeleteUserSessions(userID, sessionID)
+}
+
+func (h *handlerImpl) GetSettings(userID string) (*Settings, error) {
+	return h.userRepository.GetSettings(userID)
+}
+
+func (h *handlerImpl) UpdateSettings(userID string, settings *Settings) error {
+	if settings.TimeZone == "" {
+		return errors.New("time zone must not be empty")
+	}
+
+	if _, err := time.LoadLocation(settings.TimeZone); err != nil {
+		return fmt.Errorf("unknown time zone '%s'", settings.TimeZone)
+	}
+
+	if settings.MaxTimerDuration != nil && *settings.MaxTimerDuration <= 0 {
+		return errors.New("max timer duration must be positive")
+	}
+
+	if settings.Rounding != nil {
+		if err := settings.Rounding.Validate(); err != nil {
+			return err
+		}
+	}
+
+	previous, err := h.userRepository.GetSettings(userID)
+	if err != nil {
+		return err
+	}
+
+	if err := h.userRepository.UpdateSettings(userID, settings); err != nil {
+		return err
+	}
+
+	// the worktime is stored per day, whose boundaries depend on the time zone
+	if settings.TimeZone != previous.TimeZone {
+		if err := h.worktimeRebuilder.RebuildAllWorktime(userID); err != nil {
+			// the previous settings are restored and the days rebuilt again, so
+			// that the worktime matches the stored time zone
+			if err := h.userRepository.UpdateSettings(userID, previous); err != nil {
+				h.logger.Error("Couldn't restore settings of user '%s': %+v", userID, err)
+			} else if err := h.worktimeRebuilder.RebuildAllWorktime(userID); err != nil {
+				h.logger.Error("Couldn't rebuild worktime of user '%s' for the previous time zone: %+v", userID, err)
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CreateAccessToken creates a personal access token. The returned token
+// contains the secret, which can't be retrieved later on.
+func (h *handlerImpl) CreateAccessToken(userID, name string, scopes []Scope, expiresAt *time.Time) (*AccessToken, error) {
+	if name == "" {
+		return nil, errors.New("name must not be empty")
+	}
+
+	if len(scopes) == 0 {
+		return nil, errors.New("at least one scope must be given")
+	}
+
+	for _, scope := range scopes {
+		if !scope.Valid() {
+			return nil, fmt.Errorf("unknown scope '%s'", scope)
+		}
+	}
+
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, errors.New("expiresAt must be in the future")
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	secret = accessTokenPrefix + secret
+
+	token, err := h.tokenRepository.CreateToken(userID, name, hashToken(secret), scopes, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	token.Token = secret
+
+	return token, nil
+}
+
+func (h *handlerImpl) GetAccessTokens(userID string) ([]*AccessToken, error) {
+	return h.tokenRepository.GetTokens(userID)
+}
+
+func (h *handlerImpl) RevokeAccessToken(userID string, tokenID int) error {
+	return h.tokenRepository.DeleteToken(userID, tokenID)
+}
+
+// ValidateAccessToken returns the personal access token, if it exists and
+// didn't expire yet.
+func (h *handlerImpl) ValidateAccessToken(token string) (*AccessToken, error) {
+	if !strings.HasPrefix(token, accessTokenPrefix) {
+		return nil, errors.New("not an access token")
+	}
+
+	return h.tokenRepository.UseToken(hashToken(token))
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken hashes a random token for storing it. As the tokens are random, a
+// fast hash is sufficient.
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// ChangePassword replaces the password of the user after verifying the old
+// one. Wrong old passwords count as failed logins, so that a stolen session
+// can't be used to guess the password. All sessions of the user except
+// sessionID are logged out and all access tokens are revoked.
+func (h *handlerImpl) ChangePassword(userID, sessionID, oldPassword, newPassword string, client Client) error {
+	if newPassword == "" {
+		return errors.New("new password must not be empty")
+	}
+
+	if err := h.checkLoginLock(userID, client.IP); err != nil {
+		return err
+	}
+
+	storedHash, err := h.userRepository.GetPasswordHash(userID)
+	if err != nil {
+		return err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(oldPassword)); err != nil {
+		if err := h.recordLoginFailure(userID, client.IP); err != nil {
+			return err
+		}
+
+		return errors.New("invalid password")
+	}
+
+	if err := h.loginAttemptRepository.ResetFailures(AttemptKindAccount, userID); err != nil {
+		return err
+	}
+
+	if err := h.setPassword(userID, newPassword); err != nil {
+		return err
+	}
+
+	return h.revokeCredentials(userID, sessionID)
+}
+
+// revokeCredentials logs out all sessions of the user except keptSessionID and
+// revokes all access tokens, after the password was changed.
+func (h *handlerImpl) revokeCredentials(userID, keptSessionID string) error {
+	if err := h.sessionRepository.DeleteUserSessions(userID, keptSessionID); err != nil {
+		return err
+	}
+
+	return h.tokenRepository.DeleteUserTokens(userID)
+}
+
+// IssuePasswordReset creates a one-time token, with which the password of the
+// user can be reset.
+func (h *handlerImpl) IssuePasswordReset(userID string) (string, time.Time, error) {
+	if _, err := h.userRepository.GetPasswordHash(userID); err != nil {
+		return "", time.Time{}, err
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiry := time.Now().Add(passwordResetLength)
+	if err := h.userRepository.CreatePasswordReset(userID, hashToken(token), expiry); err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, expiry, nil
+}
+
+// ResetPassword redeems the reset token and sets the new password. All
+// sessions of the user are logged out and all access tokens are revoked.
+func (h *handlerImpl) ResetPassword(token, newPassword string) error {
+	if newPassword == "" {
+		return errors.New("new password must not be empty")
+	}
+
+	userID, err := h.userRepository.ConsumePasswordReset(hashToken(token))
+	if err != nil {
+		return err
+	}
+
+	if err := h.setPassword(userID, newPassword); err != nil {
+		return err
+	}
+
+	return h.revokeCredentials(userID, "")
+}
+
+// EnrolTOTP creates a new secret for the user, which is enabled after
+// confirming it with a code.
+func (h *handlerImpl) EnrolTOTP(userID string) (*TOTPEnrolment, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.totpRepository.SaveSecret(userID, secret); err != nil {
+		return nil, err
+	}
+
+	return &TOTPEnrolment{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(totpIssuer, userID, secret),
+	}, nil
+}
+
+// ConfirmTOTP enables two-factor authentication, if the code matches the
+// enrolled secret. The returned recovery codes are only shown once.
+func (h *handlerImpl) ConfirmTOTP(userID, code string) ([]string, error) {
+	secret, err := h.totpRepository.GetSecret(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if secret == nil || secret.Confirmed {
+		return nil, errors.New("no pending two-factor enrolment")
+	}
+
+	step, err := h.totp.Validate(secret.Secret, strings.TrimSpace(code))
+	if err != nil {
+		return nil, err
+	}
+
+	recoveryCodes := make([]string, recoveryCodeCount)
+	recoveryCodeHashes := make([]string, recoveryCodeCount)
+	for i := range recoveryCodes {
+		if recoveryCodes[i], err = randomRecoveryCode(); err != nil {
+			return nil, err
+		}
+		recoveryCodeHashes[i] = hashToken(normalizeRecoveryCode(recoveryCodes[i]))
+	}
+
+	if err := h.totpRepository.ConfirmSecret(userID, step, recoveryCodeHashes); err != nil {
+		return nil, err
+	}
+
+	return recoveryCodes, nil
+}
+
+// DisableTOTP removes the second factor after verifying the password.
+func (h *handlerImpl) DisableTOTP(userID, password string) error {
+	storedHash, err := h.userRepository.GetPasswordHash(userID)
+	if err != nil {
+		return err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(password)); err != nil {
+		return errors.New("invalid password")
+	}
+
+	return h.totpRepository.DeleteSecret(userID)
+}
+
+// randomRecoveryCode returns a code like "abcd-efgh", which is easy to type.
+func randomRecoveryCode() (string, error) {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+	return code[:4] + "-" + code[4:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(code, "-", ""))
+}
+
+func (h *handlerImpl) GetRole(userID string) (Role, error) {
+	user, err := h.userRepository.GetUser(userID)
+	if err != nil {
+		return "", err
+	}
+
+	return user.Role, nil
+}
+
+func (h *handlerImpl) GetUsers() ([]*User, error) {
+	return h.userRepository.GetUsers()
+}
+
+// AddUser creates a local user on behalf of an admin. In contrast to
+// CreateUser, no session is created.
+func (h *handlerImpl) AddUser(userID, password string, role Role) (*User, error) {
+	if userID == "" {
+		return nil, errors.New("username must not be empty")
+	}
+
+	if password == "" {
+		return nil, errors.New("password must not be empty")
+	}
+
+	if !role.Valid() {
+		return nil, fmt.Errorf("unknown role '%s'", role)
+	}
+
+	passwordHash, err := h.hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.userRepository.CreateUser(userID, passwordHash, role); err != nil {
+		return nil, err
+	}
+
+	return h.userRepository.GetUser(userID)
+}
+
+// UpdateUser changes the role or disables the user. Disabled users are logged
+// out. Admins can't demote or disable themselves, so that at least one admin
+// is left.
+func (h *handlerImpl) UpdateUser(adminID, userID string, role *Role, disabled *bool) (*User, error) {
+	if role != nil {
+		if !role.Valid() {
+			return nil, fmt.Errorf("unknown role '%s'", *role)
+		}
+
+		if userID == adminID && *role != RoleAdmin {
+			return nil, errors.New("admins can't demote themselves")
+		}
+
+		if err := h.userRepository.UpdateRole(userID, *role); err != nil {
+			return nil, err
+		}
+	}
+
+	if disabled != nil {
+		if userID == adminID && *disabled {
+			return nil, errors.New("admins can't disable themselves")
+		}
+
+		if err := h.userRepository.SetDisabled(userID, *disabled); err != nil {
+			return nil, err
+		}
+
+		if *disabled {
+			if err := h.sessionRepository.DeleteUserSessions(userID, ""); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return h.userRepository.GetUser(userID)
+}
+
+// DeleteUser deletes the user with all tracked data.
+func (h *handlerImpl) DeleteUser(adminID, userID string) error {
+	if userID == adminID {
+		return errors.New("admins can't delete themselves")
+	}
+
+	if err := h.userRepository.DeleteUser(userID); err != nil {
+		return err
+	}
+
+	h.logger.Info("Admin '%s' deleted user '%s'", adminID, userID)
+
+	return nil
+}
+
+func (h *handlerImpl) GetUsage(userID string) (*Usage, error) {
+	if _, err := h.userRepository.GetUser(userID); err != nil {
+		return nil, err
+	}
+
+	return h.userRepository.GetUsage(userID)
+}
+
+func (h *handlerImpl) setPassword(userID, password string) error {
+	passwordHash, err := h.hashPassword(password)
+	if err != nil {
+		return err
+	}
 
-	return h.createSession(claims.Sub)
+	return h.userRepository.UpdatePasswordHash(userID, passwordHash)
 }
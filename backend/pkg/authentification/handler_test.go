@@ -0,0 +1,271 @@
+package authentification
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DominikKuenkele/TimeTrack/libraries/logger"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func (r *fakeUserRepository) GetSettings(userID string) (*Settings, error) {
+	settings, found := r.settings[userID]
+	if !found {
+		return nil, errors.New("user not found")
+	}
+
+	copied := *settings
+	return &copied, nil
+}
+
+func (r *fakeUserRepository) UpdateSettings(userID string, settings *Settings) error {
+	copied := *settings
+	r.settings[userID] = &copied
+	return nil
+}
+
+// fakeWorktimeRebuilder records the time zone of the user at every rebuild and
+// fails the first rebuilds.
+type fakeWorktimeRebuilder struct {
+	users     *fakeUserRepository
+	failures  int
+	timeZones []string
+}
+
+func (r *fakeWorktimeRebuilder) RebuildAllWorktime(userID string) error {
+	r.timeZones = append(r.timeZones, r.users.settings[userID].TimeZone)
+	if r.failures > 0 {
+		r.failures--
+		return errors.New("rebuild failed")
+	}
+
+	return nil
+}
+
+func newSettingsTest(t *testing.T, failures int) (Handler, *fakeUserRepository, *fakeWorktimeRebuilder) {
+	t.Helper()
+
+	log, err := logger.NewLogger("error", "")
+	if err != nil {
+		t.Fatalf("error creating logger: %+v", err)
+	}
+
+	users := &fakeUserRepository{
+		users:    map[string]*User{},
+		settings: map[string]*Settings{"alice": {TimeZone: "UTC"}},
+	}
+	rebuilder := &fakeWorktimeRebuilder{users: users, failures: failures}
+
+	handler, err := NewHandler(log, nil, users, nil, nil, nil, nil, rebuilder, nil, SessionConfig{}, "", "", "", "", nil, nil)
+	if err != nil {
+		t.Fatalf("error creating handler: %+v", err)
+	}
+
+	return handler, users, rebuilder
+}
+
+func TestUpdateSettingsRebuildsWorktime(t *testing.T) {
+	handler, users, rebuilder := newSettingsTest(t, 0)
+
+	if err := handler.UpdateSettings("alice", &Settings{TimeZone: "Europe/Berlin"}); err != nil {
+		t.Fatalf("error updating settings: %+v", err)
+	}
+
+	if timeZone := users.settings["alice"].TimeZone; timeZone != "Europe/Berlin" {
+		t.Errorf("expected time zone 'Europe/Berlin', got '%s'", timeZone)
+	}
+	if len(rebuilder.timeZones) != 1 || rebuilder.timeZones[0] != "Europe/Berlin" {
+		t.Errorf("expected one rebuild for the new time zone, got %v", rebuilder.timeZones)
+	}
+}
+
+func TestUpdateSettingsRestoresTimeZoneOnFailedRebuild(t *testing.T) {
+	handler, users, rebuilder := newSettingsTest(t, 1)
+
+	maxTimerDuration := int((8 * time.Hour).Seconds())
+	if err := handler.UpdateSettings("alice", &Settings{TimeZone: "Europe/Berlin", MaxTimerDuration: &maxTimerDuration}); err == nil {
+		t.Fatalf("expected failed rebuild to be returned")
+	}
+
+	settings := users.settings["alice"]
+	if settings.TimeZone != "UTC" || settings.MaxTimerDuration != nil {
+		t.Errorf("expected previous settings to be restored, got %+v", settings)
+	}
+	if len(rebuilder.timeZones) != 2 || rebuilder.timeZones[1] != "UTC" {
+		t.Errorf("expected worktime to be rebuilt for the previous time zone, got %v", rebuilder.timeZones)
+	}
+}
+
+func (r *fakeSessionRepository) DeleteUserSessions(userID, exceptSessionID string) error {
+	for sessionID, sessionUserID := range r.sessions {
+		if sessionUserID == userID && sessionID != exceptSessionID {
+			delete(r.sessions, sessionID)
+		}
+	}
+
+	return nil
+}
+
+func (r *fakeUserRepository) GetPasswordHash(userID string) (string, error) {
+	passwordHash, found := r.passwordHashes[userID]
+	if !found {
+		return "", errors.New("user not found")
+	}
+
+	return passwordHash, nil
+}
+
+func (r *fakeUserRepository) ConsumePasswordReset(tokenHash string) (string, error) {
+	userID, found := r.resetTokens[tokenHash]
+	if !found {
+		return "", errors.New("invalid reset token")
+	}
+
+	delete(r.resetTokens, tokenHash)
+	return userID, nil
+}
+
+func (r *fakeUserRepository) UpdatePasswordHash(userID, passwordHash string) error {
+	r.passwordHashes[userID] = passwordHash
+	return nil
+}
+
+type fakeTokenRepository struct {
+	TokenRepository
+	tokens map[string]int
+}
+
+func (r *fakeTokenRepository) DeleteUserTokens(userID string) error {
+	delete(r.tokens, userID)
+	return nil
+}
+
+// fakeLoginAttemptRepository counts the failures per key, which never expire.
+type fakeLoginAttemptRepository struct {
+	LoginAttemptRepository
+	failures    map[string]int
+	lockedUntil map[string]time.Time
+}
+
+func (r *fakeLoginAttemptRepository) GetLockedUntil(kind AttemptKind, key string) (*time.Time, error) {
+	lockedUntil, found := r.lockedUntil[string(kind)+":"+key]
+	if !found {
+		return nil, nil
+	}
+
+	return &lockedUntil, nil
+}
+
+func (r *fakeLoginAttemptRepository) RecordFailure(kind AttemptKind, key string, resetAfter time.Duration) (int, error) {
+	r.failures[string(kind)+":"+key]++
+	return r.failures[string(kind)+":"+key], nil
+}
+
+func (r *fakeLoginAttemptRepository) LockUntil(kind AttemptKind, key string, lockedUntil time.Time) error {
+	r.lockedUntil[string(kind)+":"+key] = lockedUntil
+	return nil
+}
+
+func (r *fakeLoginAttemptRepository) ResetFailures(kind AttemptKind, key string) error {
+	delete(r.failures, string(kind)+":"+key)
+	return nil
+}
+
+type passwordTest struct {
+	handler       Handler
+	sessions      *fakeSessionRepository
+	users         *fakeUserRepository
+	tokens        *fakeTokenRepository
+	loginAttempts *fakeLoginAttemptRepository
+}
+
+// newPasswordTest creates a handler for alice with the password "secret", two
+// sessions and two access tokens.
+func newPasswordTest(t *testing.T) *passwordTest {
+	t.Helper()
+
+	log, err := logger.NewLogger("error", "")
+	if err != nil {
+		t.Fatalf("error creating logger: %+v", err)
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("error hashing password: %+v", err)
+	}
+
+	test := &passwordTest{
+		sessions: &fakeSessionRepository{sessions: map[string]string{"current": "alice", "other": "alice"}},
+		users: &fakeUserRepository{
+			users:          map[string]*User{"alice": {ID: "alice", Role: RoleMember}},
+			passwordHashes: map[string]string{"alice": string(passwordHash)},
+		},
+		tokens: &fakeTokenRepository{tokens: map[string]int{"alice": 2}},
+		loginAttempts: &fakeLoginAttemptRepository{
+			failures:    map[string]int{},
+			lockedUntil: map[string]time.Time{},
+		},
+	}
+
+	test.handler, err = NewHandler(log, test.sessions, test.users, test.tokens, test.loginAttempts, nil, nil, nil, nil, SessionConfig{}, "", "", "", "", nil, nil)
+	if err != nil {
+		t.Fatalf("error creating handler: %+v", err)
+	}
+
+	return test
+}
+
+func TestChangePasswordRevokesCredentials(t *testing.T) {
+	test := newPasswordTest(t)
+
+	if err := test.handler.ChangePassword("alice", "current", "secret", "new secret", Client{IP: "192.0.2.1"}); err != nil {
+		t.Fatalf("error changing password: %+v", err)
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(test.users.passwordHashes["alice"]), []byte("new secret")); err != nil {
+		t.Errorf("expected the new password to be stored")
+	}
+	if _, found := test.sessions.sessions["current"]; !found || len(test.sessions.sessions) != 1 {
+		t.Errorf("expected only the current session to be kept, got %v", test.sessions.sessions)
+	}
+	if _, found := test.tokens.tokens["alice"]; found {
+		t.Errorf("expected the access tokens to be revoked")
+	}
+}
+
+func TestChangePasswordCountsFailures(t *testing.T) {
+	test := newPasswordTest(t)
+	client := Client{IP: "192.0.2.1"}
+
+	for i := 0; i < accountLoginThreshold; i++ {
+		if err := test.handler.ChangePassword("alice", "current", "wrong", "new secret", client); err == nil {
+			t.Fatalf("expected wrong old password to be rejected")
+		}
+	}
+
+	// the account is locked, so that even the right password is rejected
+	var lockedError *LoginLockedError
+	if err := test.handler.ChangePassword("alice", "current", "secret", "new secret", client); !errors.As(err, &lockedError) {
+		t.Fatalf("expected LoginLockedError, got %+v", err)
+	}
+	if len(test.sessions.sessions) != 2 || test.tokens.tokens["alice"] != 2 {
+		t.Errorf("expected no credentials to be revoked")
+	}
+}
+
+func TestResetPasswordRevokesCredentials(t *testing.T) {
+	test := newPasswordTest(t)
+	test.users.resetTokens = map[string]string{hashToken("reset"): "alice"}
+
+	if err := test.handler.ResetPassword("reset", "new secret"); err != nil {
+		t.Fatalf("error resetting password: %+v", err)
+	}
+
+	if len(test.sessions.sessions) != 0 {
+		t.Errorf("expected all sessions to be logged out, got %v", test.sessions.sessions)
+	}
+	if _, found := test.tokens.tokens["alice"]; found {
+		t.Errorf("expected the access tokens to be revoked")
+	}
+}
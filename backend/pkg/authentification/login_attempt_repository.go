@@ -0,0 +1,126 @@
+package authentification
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/DominikKuenkele/TimeTrack/libraries/database"
+	"github.com/DominikKuenkele/TimeTrack/libraries/logger"
+)
+
+type AttemptKind string
+
+const (
+	AttemptKindAccount AttemptKind = "account"
+	AttemptKindIP      AttemptKind = "ip"
+)
+
+// LoginAttemptRepository tracks failed logins per account and per IP, so that
+// lockouts survive restarts.
+type LoginAttemptRepository interface {
+	GetLockedUntil(kind AttemptKind, key string) (*time.Time, error)
+	RecordFailure(kind AttemptKind, key string, resetAfter time.Duration) (int, error)
+	LockUntil(kind AttemptKind, key string, lockedUntil time.Time) error
+	ResetFailures(kind AttemptKind, key string) error
+}
+
+type loginAttemptRepositoryImpl struct {
+	logger   logger.Logger
+	database database.Database
+}
+
+var _ LoginAttemptRepository = &loginAttemptRepositoryImpl{}
+
+func NewLoginAttemptRepository(logger logger.Logger, database database.Database) (LoginAttemptRepository, error) {
+	if database == nil {
+		return nil, errors.New("database must not be nil")
+	}
+
+	return &loginAttemptRepositoryImpl{
+		logger:   logger,
+		database: database,
+	}, nil
+}
+
+const (
+	tableLoginAttempts              = "login_attempts"
+	columnLoginAttemptsKind         = "kind"
+	columnLoginAttemptsKey          = "key"
+	columnLoginAttemptsFailures     = "failures"
+	columnLoginAttemptsLockedUntil  = "locked_until"
+	columnLoginAttemptsLastFailedAt = "last_failed_at"
+)
+
+// GetLockedUntil returns the end of the lockout or nil, if logins aren't
+// locked.
+func (r *loginAttemptRepositoryImpl) GetLockedUntil(kind AttemptKind, key string) (*time.Time, error) {
+	var lockedUntil sql.NullTime
+	if err := r.database.QueryRow(
+		"SELECT "+columnLoginAttemptsLockedUntil+
+			" FROM "+tableLoginAttempts+
+			" WHERE "+columnLoginAttemptsKind+"=$1 AND "+columnLoginAttemptsKey+"=$2 AND "+columnLoginAttemptsLockedUntil+">NOW();",
+		[]any{kind, key},
+		&lockedUntil,
+	); err != nil {
+		switch {
+		case errors.As(err, &database.NoRowsError{}):
+			return nil, nil
+		default:
+			return nil, r.logger.LogAndAbstractError("database error", "Error scanning login attempts: %+v", err)
+		}
+	}
+
+	return &lockedUntil.Time, nil
+}
+
+// RecordFailure counts a failed login and returns the number of consecutive
+// failures. The count starts over, if the last failure is older than
+// resetAfter.
+func (r *loginAttemptRepositoryImpl) RecordFailure(kind AttemptKind, key string, resetAfter time.Duration) (int, error) {
+	var failures int
+	if err := r.database.QueryRow(
+		"INSERT"+
+			" INTO "+tableLoginAttempts+
+			" ("+columnLoginAttemptsKind+", "+columnLoginAttemptsKey+", "+columnLoginAttemptsFailures+", "+columnLoginAttemptsLastFailedAt+")"+
+			" VALUES ($1, $2, 1, NOW())"+
+			" ON CONFLICT ("+columnLoginAttemptsKind+", "+columnLoginAttemptsKey+") DO UPDATE"+
+			" SET "+columnLoginAttemptsFailures+"=CASE"+
+			" WHEN "+tableLoginAttempts+"."+columnLoginAttemptsLastFailedAt+"<NOW()-$3*INTERVAL '1 second' THEN 1"+
+			" ELSE "+tableLoginAttempts+"."+columnLoginAttemptsFailures+"+1 END"+
+			", "+columnLoginAttemptsLastFailedAt+"=NOW()"+
+			" RETURNING "+columnLoginAttemptsFailures+";",
+		[]any{kind, key, int64(resetAfter.Seconds())},
+		&failures,
+	); err != nil {
+		return 0, r.logger.LogAndAbstractError("database error", "Couldn't record failed login: %+v", err)
+	}
+
+	return failures, nil
+}
+
+func (r *loginAttemptRepositoryImpl) LockUntil(kind AttemptKind, key string, lockedUntil time.Time) error {
+	if _, err := r.database.Exec(
+		"UPDATE "+tableLoginAttempts+
+			" SET "+columnLoginAttemptsLockedUntil+"=$3"+
+			" WHERE "+columnLoginAttemptsKind+"=$1 AND "+columnLoginAttemptsKey+"=$2;",
+		kind, key, lockedUntil,
+	); err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't lock logins: %+v", err)
+	}
+
+	return nil
+}
+
+func (r *loginAttemptRepositoryImpl) ResetFailures(kind AttemptKind, key string) error {
+	if _, err := r.database.Exec(
+		"DELETE"+
+			" FROM "+tableLoginAttempts+
+			" WHERE "+columnLoginAttemptsKind+"=$1 AND "+columnLoginAttemptsKey+"=$2;",
+		kind, key,
+	); err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't reset failed logins: %+v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+package authentification
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DominikKuenkele/TimeTrack/libraries/logger"
+	"github.com/DominikKuenkele/TimeTrack/libraries/totp"
+)
+
+// loginSecret is the base32 encoded secret "12345678901234567890".
+const loginSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+type fakeTOTPRepository struct {
+	TOTPRepository
+	secrets       map[string]*TOTPSecret
+	recoveryCodes map[string]map[string]bool
+	challenges    map[string]string
+}
+
+func (r *fakeTOTPRepository) GetSecret(userID string) (*TOTPSecret, error) {
+	return r.secrets[userID], nil
+}
+
+func (r *fakeTOTPRepository) UseStep(userID string, step int64) error {
+	secret := r.secrets[userID]
+	if secret.LastUsedStep != nil && step <= *secret.LastUsedStep {
+		return errors.New("code was already used")
+	}
+
+	secret.LastUsedStep = &step
+	return nil
+}
+
+func (r *fakeTOTPRepository) UseRecoveryCode(userID, codeHash string) error {
+	if !r.recoveryCodes[userID][codeHash] {
+		return errors.New("invalid code")
+	}
+
+	delete(r.recoveryCodes[userID], codeHash)
+	return nil
+}
+
+func (r *fakeTOTPRepository) CreateChallenge(challengeHash, userID string, expiresAt time.Time) error {
+	r.challenges[challengeHash] = userID
+	return nil
+}
+
+func (r *fakeTOTPRepository) GetChallengeUser(challengeHash string) (string, error) {
+	userID, found := r.challenges[challengeHash]
+	if !found {
+		return "", errors.New("invalid challenge")
+	}
+
+	return userID, nil
+}
+
+func (r *fakeTOTPRepository) DeleteChallenge(challengeHash string) error {
+	delete(r.challenges, challengeHash)
+	return nil
+}
+
+type loginTest struct {
+	*passwordTest
+	totpRepository *fakeTOTPRepository
+	now            time.Time
+}
+
+// newLoginTest creates a handler for alice with the password "secret", two-
+// factor authentication and the recovery code "abcd-efgh". The clock of the
+// codes is fixed.
+func newLoginTest(t *testing.T) *loginTest {
+	t.Helper()
+
+	test := &loginTest{
+		passwordTest: newPasswordTest(t),
+		totpRepository: &fakeTOTPRepository{
+			secrets: map[string]*TOTPSecret{"alice": {Secret: loginSecret, Confirmed: true}},
+			recoveryCodes: map[string]map[string]bool{
+				"alice": {hashToken(normalizeRecoveryCode("abcd-efgh")): true},
+			},
+			challenges: map[string]string{},
+		},
+		now: time.Unix(1111111111, 0),
+	}
+	test.sessions.sessions = map[string]string{}
+
+	log, err := logger.NewLogger("error", "")
+	if err != nil {
+		t.Fatalf("error creating logger: %+v", err)
+	}
+
+	test.handler, err = NewHandler(log, test.sessions, test.users, test.tokens, test.loginAttempts, test.totpRepository, nil, nil, totp.New(func() time.Time { return test.now }), SessionConfig{}, "", "", "", "", nil, nil)
+	if err != nil {
+		t.Fatalf("error creating handler: %+v", err)
+	}
+
+	return test
+}
+
+// challenge logs alice in with her password and returns the challenge.
+func (test *loginTest) challenge(t *testing.T) string {
+	t.Helper()
+
+	sessionID, _, challenge, err := test.handler.Login("alice", "secret", Client{IP: "192.0.2.1"}, false)
+	if err != nil {
+		t.Fatalf("error logging in: %+v", err)
+	}
+	if sessionID != "" || challenge == nil {
+		t.Fatalf("expected a challenge instead of a session")
+	}
+
+	return challenge.Challenge
+}
+
+func (test *loginTest) code(t *testing.T) string {
+	t.Helper()
+
+	code, err := totp.Code(loginSecret, test.now)
+	if err != nil {
+		t.Fatalf("error computing code: %+v", err)
+	}
+
+	return code
+}
+
+func TestLoginReturnsChallenge(t *testing.T) {
+	test := newLoginTest(t)
+
+	test.challenge(t)
+
+	if len(test.sessions.sessions) != 0 {
+		t.Errorf("expected no session before the second factor, got %v", test.sessions.sessions)
+	}
+}
+
+func TestCompleteLoginWithCode(t *testing.T) {
+	test := newLoginTest(t)
+	challenge := test.challenge(t)
+
+	sessionID, _, err := test.handler.CompleteLogin(challenge, test.code(t), Client{IP: "192.0.2.1"}, false)
+	if err != nil {
+		t.Fatalf("error completing login: %+v", err)
+	}
+
+	if test.sessions.sessions[sessionID] != "alice" {
+		t.Errorf("expected a session for alice, got %v", test.sessions.sessions)
+	}
+	if _, found := test.totpRepository.challenges[hashToken(challenge)]; found {
+		t.Errorf("expected the challenge to be deleted")
+	}
+}
+
+func TestCompleteLoginRejectsReplayedCode(t *testing.T) {
+	test := newLoginTest(t)
+	code := test.code(t)
+
+	if _, _, err := test.handler.CompleteLogin(test.challenge(t), code, Client{IP: "192.0.2.1"}, false); err != nil {
+		t.Fatalf("error completing login: %+v", err)
+	}
+
+	if _, _, err := test.handler.CompleteLogin(test.challenge(t), code, Client{IP: "192.0.2.1"}, false); err == nil {
+		t.Errorf("expected replayed code to be rejected")
+	}
+	if len(test.sessions.sessions) != 1 {
+		t.Errorf("expected 1 session, got %v", test.sessions.sessions)
+	}
+}
+
+func TestCompleteLoginWithRecoveryCode(t *testing.T) {
+	test := newLoginTest(t)
+
+	if _, _, err := test.handler.CompleteLogin(test.challenge(t), "ABCD-EFGH", Client{IP: "192.0.2.1"}, false); err != nil {
+		t.Fatalf("error completing login with recovery code: %+v", err)
+	}
+
+	if _, _, err := test.handler.CompleteLogin(test.challenge(t), "ABCD-EFGH", Client{IP: "192.0.2.1"}, false); err == nil {
+		t.Errorf("expected recovery code to be accepted only once")
+	}
+}
+
+func TestCompleteLoginCountsInvalidCode(t *testing.T) {
+	test := newLoginTest(t)
+	challenge := test.challenge(t)
+
+	if _, _, err := test.handler.CompleteLogin(challenge, "000000", Client{IP: "192.0.2.1"}, false); err == nil {
+		t.Fatalf("expected invalid code to be rejected")
+	}
+
+	if len(test.sessions.sessions) != 0 {
+		t.Errorf("expected no session, got %v", test.sessions.sessions)
+	}
+	if failures := test.loginAttempts.failures[string(AttemptKindAccount)+":alice"]; failures != 1 {
+		t.Errorf("expected 1 failed login of the account, got %d", failures)
+	}
+	if failures := test.loginAttempts.failures[string(AttemptKindIP)+":192.0.2.1"]; failures != 1 {
+		t.Errorf("expected 1 failed login of the IP, got %d", failures)
+	}
+	if _, found := test.totpRepository.challenges[hashToken(challenge)]; !found {
+		t.Errorf("expected the challenge to be kept for another code")
+	}
+}
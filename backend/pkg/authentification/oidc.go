@@ -0,0 +1,139 @@
+package authentification
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/DominikKuenkele/TimeTrack/libraries/logger"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// Failed discoveries of the identity provider are retried after a backoff,
+// which doubles with every failure.
+const (
+	oidcRetryBase    = 5 * time.Second
+	oidcRetryMax     = 5 * time.Minute
+	oidcRetryTimeout = 10 * time.Second
+)
+
+var errOIDCNotConfigured = errors.New("OIDC is not configured")
+
+// oidcProvider discovers the identity provider on first use instead of at
+// startup, so that local logins keep working while it is unavailable. The
+// discovered provider is cached.
+type oidcProvider struct {
+	logger       logger.Logger
+	serverURL    string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+
+	mutex        sync.Mutex
+	provider     *oidc.Provider
+	verifier     *oidc.IDTokenVerifier
+	oauth2Config *oauth2.Config
+	failures     int
+	nextAttempt  time.Time
+}
+
+// newOIDCProvider returns nil, if no server URL is configured. The
+// authorization code flow is only available with a redirect URL.
+func newOIDCProvider(logger logger.Logger, serverURL, clientID, clientSecret, redirectURL string) (*oidcProvider, error) {
+	if serverURL == "" {
+		return nil, nil
+	}
+
+	if clientID == "" {
+		return nil, errors.New("OIDC client ID must be set together with the server URL")
+	}
+
+	return &oidcProvider{
+		logger:       logger,
+		serverURL:    serverURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+	}, nil
+}
+
+// context returns a context for requests to the identity provider, which
+// time out.
+func (p *oidcProvider) context() context.Context {
+	client := &http.Client{Timeout: oidcRetryTimeout}
+	ctx := oidc.ClientContext(context.Background(), client)
+	return context.WithValue(ctx, oauth2.HTTPClient, client)
+}
+
+// get returns the verifier for ID tokens. Until the backoff after a failed
+// discovery passed, an error is returned without contacting the provider.
+func (p *oidcProvider) get() (*oidc.IDTokenVerifier, error) {
+	if p == nil {
+		return nil, errOIDCNotConfigured
+	}
+
+	if err := p.discover(); err != nil {
+		return nil, err
+	}
+
+	return p.verifier, nil
+}
+
+// getOAuth2Config returns the configuration for the authorization code flow.
+func (p *oidcProvider) getOAuth2Config() (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	if p == nil || p.redirectURL == "" {
+		return nil, nil, errors.New("OIDC login is not configured")
+	}
+
+	if err := p.discover(); err != nil {
+		return nil, nil, err
+	}
+
+	return p.oauth2Config, p.verifier, nil
+}
+
+func (p *oidcProvider) discover() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.provider != nil {
+		return nil
+	}
+
+	if time.Now().Before(p.nextAttempt) {
+		return errors.New("identity provider is unavailable")
+	}
+
+	// the client of the context is kept by the provider for fetching the
+	// signing keys
+	provider, err := oidc.NewProvider(p.context(), p.serverURL)
+	if err != nil {
+		backoff := oidcRetryMax
+		if p.failures < 16 {
+			backoff = min(oidcRetryBase<<p.failures, oidcRetryMax)
+		}
+		p.failures++
+		p.nextAttempt = time.Now().Add(backoff)
+
+		p.logger.Error("Couldn't discover OIDC provider, retrying in %s: %+v", backoff, err)
+		return errors.New("identity provider is unavailable")
+	}
+
+	p.provider = provider
+	p.verifier = provider.Verifier(&oidc.Config{
+		ClientID: p.clientID,
+	})
+	p.oauth2Config = &oauth2.Config{
+		ClientID:     p.clientID,
+		ClientSecret: p.clientSecret,
+		RedirectURL:  p.redirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+	}
+	p.failures = 0
+
+	return nil
+}
@@ -0,0 +1,246 @@
+package authentification
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/DominikKuenkele/TimeTrack/libraries/database"
+	"github.com/DominikKuenkele/TimeTrack/libraries/logger"
+)
+
+type OIDCRepository interface {
+	CreateLoginState(stateHash string, state *OIDCLoginState, expiresAt time.Time) error
+	ConsumeLoginState(stateHash string) (*OIDCLoginState, error)
+	GetIdentityUser(issuer, subject string) (string, error)
+	ProvisionUser(userID string, profile Profile, role Role, issuer, subject string) error
+	LinkIdentity(userID, issuer, subject, email string) error
+	GetIdentities(userID string) ([]*Identity, error)
+	DeleteIdentity(userID string, identityID int) error
+}
+
+type oidcRepositoryImpl struct {
+	logger   logger.Logger
+	database database.Database
+}
+
+var _ OIDCRepository = &oidcRepositoryImpl{}
+
+func NewOIDCRepository(logger logger.Logger, database database.Database) (OIDCRepository, error) {
+	if database == nil {
+		return nil, errors.New("database must not be nil")
+	}
+
+	return &oidcRepositoryImpl{
+		logger:   logger,
+		database: database,
+	}, nil
+}
+
+const (
+	tableOIDCLoginStates           = "oidc_login_states"
+	columnOIDCLoginStatesStateHash = "state_hash"
+	columnOIDCLoginStatesNonce     = "nonce"
+	columnOIDCLoginStatesVerifier  = "verifier"
+	columnOIDCLoginStatesRemember  = "remember_me"
+	columnOIDCLoginStatesLinkUser  = "link_user_id"
+	columnOIDCLoginStatesExpiresAt = "expires_at"
+
+	tableUserIdentities            = "user_identities"
+	columnUserIdentitiesIdentityID = "identity_id"
+	columnUserIdentitiesUserID     = "user_id"
+	columnUserIdentitiesIssuer     = "issuer"
+	columnUserIdentitiesSubject    = "subject"
+	columnUserIdentitiesEmail      = "email"
+	columnUserIdentitiesCreatedAt  = "created_at"
+)
+
+func (r *oidcRepositoryImpl) CreateLoginState(stateHash string, state *OIDCLoginState, expiresAt time.Time) error {
+	if _, err := r.database.Exec(
+		"INSERT"+
+			" INTO "+tableOIDCLoginStates+
+			" ("+columnOIDCLoginStatesStateHash+", "+columnOIDCLoginStatesNonce+", "+columnOIDCLoginStatesVerifier+", "+columnOIDCLoginStatesRemember+", "+columnOIDCLoginStatesLinkUser+", "+columnOIDCLoginStatesExpiresAt+")"+
+			" VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6);",
+		stateHash, state.Nonce, state.Verifier, state.RememberMe, state.LinkUserID, expiresAt,
+	); err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't create OIDC login state: %+v", err)
+	}
+
+	// expired states of abandoned logins are removed whenever a new one is
+	// created
+	if _, err := r.database.Exec(
+		"DELETE" +
+			" FROM " + tableOIDCLoginStates +
+			" WHERE " + columnOIDCLoginStatesExpiresAt + "<NOW();",
+	); err != nil {
+		r.logger.Error("Error while cleaning up OIDC login states: %+v", err)
+	}
+
+	return nil
+}
+
+// ConsumeLoginState deletes the unexpired state and returns it, so that it
+// can only be used once.
+func (r *oidcRepositoryImpl) ConsumeLoginState(stateHash string) (*OIDCLoginState, error) {
+	var (
+		state      OIDCLoginState
+		linkUserID sql.NullString
+	)
+	if err := r.database.QueryRow(
+		"DELETE"+
+			" FROM "+tableOIDCLoginStates+
+			" WHERE "+columnOIDCLoginStatesStateHash+"=$1 AND "+columnOIDCLoginStatesExpiresAt+">NOW()"+
+			" RETURNING "+columnOIDCLoginStatesNonce+", "+columnOIDCLoginStatesVerifier+", "+columnOIDCLoginStatesRemember+", "+columnOIDCLoginStatesLinkUser+";",
+		[]any{stateHash},
+		&state.Nonce, &state.Verifier, &state.RememberMe, &linkUserID,
+	); err != nil {
+		switch {
+		case errors.As(err, &database.NoRowsError{}):
+			return nil, errors.New("OIDC login not found or expired")
+		default:
+			return nil, r.logger.LogAndAbstractError("database error", "Couldn't consume OIDC login state: %+v", err)
+		}
+	}
+	state.LinkUserID = linkUserID.String
+
+	return &state, nil
+}
+
+// GetIdentityUser returns the user, to which the identity belongs, or an empty
+// string, if the identity is unknown.
+func (r *oidcRepositoryImpl) GetIdentityUser(issuer, subject string) (string, error) {
+	var userID string
+	if err := r.database.QueryRow(
+		"SELECT "+columnUserIdentitiesUserID+
+			" FROM "+tableUserIdentities+
+			" WHERE "+columnUserIdentitiesIssuer+"=$1 AND "+columnUserIdentitiesSubject+"=$2;",
+		[]any{issuer, subject},
+		&userID,
+	); err != nil {
+		switch {
+		case errors.As(err, &database.NoRowsError{}):
+			return "", nil
+		default:
+			return "", r.logger.LogAndAbstractError("database error", "Error scanning identity: %+v", err)
+		}
+	}
+
+	return userID, nil
+}
+
+// ProvisionUser creates a user without password for the identity.
+func (r *oidcRepositoryImpl) ProvisionUser(userID string, profile Profile, role Role, issuer, subject string) error {
+	tx, err := r.database.Begin()
+	if err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't create transaction: %+v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := r.database.ExecWithTx(
+		tx,
+		"INSERT"+
+			" INTO "+tableUsers+
+			" ("+columnUserID+", "+columnEmail+", "+columnDisplayName+", "+columnRole+")"+
+			" VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), $4);",
+		userID, profile.Email, profile.DisplayName, role,
+	); err != nil {
+		switch {
+		case errors.As(err, &database.DuplicateError{}):
+			return fmt.Errorf("user '%s' already exists. Log in and link the identity to the account", userID)
+		default:
+			return r.logger.LogAndAbstractError("database error", "Couldn't provision user: %+v", err)
+		}
+	}
+
+	if err := r.linkIdentityWithTx(tx, userID, issuer, subject, profile.Email); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't commit transaction: %+v", err)
+	}
+
+	return nil
+}
+
+func (r *oidcRepositoryImpl) LinkIdentity(userID, issuer, subject, email string) error {
+	return r.linkIdentityWithTx(nil, userID, issuer, subject, email)
+}
+
+// linkIdentityWithTx runs without transaction, if tx is nil.
+func (r *oidcRepositoryImpl) linkIdentityWithTx(tx *sql.Tx, userID, issuer, subject, email string) error {
+	query := "INSERT" +
+		" INTO " + tableUserIdentities +
+		" (" + columnUserIdentitiesUserID + ", " + columnUserIdentitiesIssuer + ", " + columnUserIdentitiesSubject + ", " + columnUserIdentitiesEmail + ")" +
+		" VALUES ($1, $2, $3, NULLIF($4, ''));"
+
+	var err error
+	if tx == nil {
+		_, err = r.database.Exec(query, userID, issuer, subject, email)
+	} else {
+		_, err = r.database.ExecWithTx(tx, query, userID, issuer, subject, email)
+	}
+	if err != nil {
+		switch {
+		case errors.As(err, &database.DuplicateError{}):
+			return errors.New("identity is already linked to an account")
+		default:
+			return r.logger.LogAndAbstractError("database error", "Couldn't link identity: %+v", err)
+		}
+	}
+
+	return nil
+}
+
+func (r *oidcRepositoryImpl) GetIdentities(userID string) ([]*Identity, error) {
+	rows, err := r.database.Query(
+		"SELECT "+columnUserIdentitiesIdentityID+", "+columnUserIdentitiesIssuer+", "+columnUserIdentitiesSubject+", "+columnUserIdentitiesEmail+", "+columnUserIdentitiesCreatedAt+
+			" FROM "+tableUserIdentities+
+			" WHERE "+columnUserIdentitiesUserID+"=$1"+
+			" ORDER BY "+columnUserIdentitiesCreatedAt+" ASC;",
+		userID,
+	)
+	if err != nil {
+		return nil, r.logger.LogAndAbstractError("database error", "Error getting identities: %+v", err)
+	}
+	defer rows.Close()
+
+	identities := []*Identity{}
+	for rows.Next() {
+		var (
+			identity Identity
+			email    sql.NullString
+		)
+		if err := rows.Scan(&identity.ID, &identity.Issuer, &identity.Subject, &email, &identity.CreatedAt); err != nil {
+			return nil, r.logger.LogAndAbstractError("database error", "Error scanning identity: %+v", err)
+		}
+		identity.Email = email.String
+
+		identities = append(identities, &identity)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, r.logger.LogAndAbstractError("database error", "Error iterating identities: %+v", err)
+	}
+
+	return identities, nil
+}
+
+func (r *oidcRepositoryImpl) DeleteIdentity(userID string, identityID int) error {
+	res, err := r.database.Exec(
+		"DELETE"+
+			" FROM "+tableUserIdentities+
+			" WHERE "+columnUserIdentitiesUserID+"=$1 AND "+columnUserIdentitiesIdentityID+"=$2;",
+		userID, identityID,
+	)
+	if err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't delete identity: %+v", err)
+	}
+
+	if rows, _ := res.RowsAffected(); rows != 1 {
+		return fmt.Errorf("identity '%d' not found", identityID)
+	}
+
+	return nil
+}
@@ -0,0 +1,480 @@
+package authentification
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DominikKuenkele/TimeTrack/libraries/logger"
+)
+
+const (
+	testClientID = "timetrack"
+	testKeyID    = "test-key"
+)
+
+// testProvider is an identity provider, which serves the discovery document,
+// the signing keys and the token endpoint. Codes are issued by the test
+// instead of an authorization endpoint.
+type testProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mutex  sync.Mutex
+	codes  map[string]testAuthorization
+	groups map[string][]string
+}
+
+// testAuthorization is what the provider remembers about an issued code.
+type testAuthorization struct {
+	challenge string
+	nonce     string
+	subject   string
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating key: %+v", err)
+	}
+
+	p := &testProvider{
+		t:      t,
+		key:    key,
+		codes:  map[string]testAuthorization{},
+		groups: map[string][]string{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.handleDiscovery)
+	mux.HandleFunc("/keys", p.handleKeys)
+	mux.HandleFunc("/token", p.handleToken)
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+
+	return p
+}
+
+func (p *testProvider) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.server.URL,
+		"authorization_endpoint":                p.server.URL + "/authorize",
+		"token_endpoint":                        p.server.URL + "/token",
+		"jwks_uri":                              p.server.URL + "/keys",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (p *testProvider) handleKeys(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": testKeyID,
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+// handleToken exchanges a code for an ID token, if the verifier matches the
+// challenge the code was issued for.
+func (p *testProvider) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	p.mutex.Lock()
+	authorization, found := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	groups := p.groups[authorization.subject]
+	p.mutex.Unlock()
+
+	if !found || s256(r.PostForm.Get("code_verifier")) != authorization.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": "access-token",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token": p.sign(map[string]any{
+			"iss":    p.server.URL,
+			"sub":    authorization.subject,
+			"aud":    testClientID,
+			"iat":    now.Unix(),
+			"exp":    now.Add(time.Hour).Unix(),
+			"nonce":  authorization.nonce,
+			"email":  authorization.subject + "@example.com",
+			"name":   "Test User",
+			"groups": groups,
+		}),
+	})
+}
+
+// sign returns the claims as JWT signed with RS256.
+func (p *testProvider) sign(claims map[string]any) string {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": testKeyID, "typ": "JWT"})
+	if err != nil {
+		p.t.Fatalf("error encoding header: %+v", err)
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		p.t.Fatalf("error encoding claims: %+v", err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		p.t.Fatalf("error signing token: %+v", err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// authorize reads the PKCE challenge and the nonce from the URL, to which the
+// user is redirected.
+func (p *testProvider) authorize(authURL string) (string, string) {
+	p.t.Helper()
+
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		p.t.Fatalf("error parsing authorization URL: %+v", err)
+	}
+
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" {
+		p.t.Fatalf("expected S256 challenge, got '%s'", query.Get("code_challenge_method"))
+	}
+
+	return query.Get("code_challenge"), query.Get("nonce")
+}
+
+// issueCode returns a code, which is exchanged for an ID token of the subject.
+func (p *testProvider) issueCode(challenge, nonce, subject string) string {
+	p.t.Helper()
+
+	code, err := randomToken()
+	if err != nil {
+		p.t.Fatalf("error generating code: %+v", err)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.codes[code] = testAuthorization{challenge: challenge, nonce: nonce, subject: subject}
+
+	return code
+}
+
+func s256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(body)
+}
+
+// The fake repositories keep their data in memory. Methods, which the tests
+// don't use, aren't implemented.
+
+type fakeSessionRepository struct {
+	SessionRepository
+	sessions map[string]string
+}
+
+func (r *fakeSessionRepository) CreateSession(sessionID, userID string, client Client, idleTimeout time.Duration, expiresAt, maxExpiresAt time.Time) error {
+	r.sessions[sessionID] = userID
+	return nil
+}
+
+type fakeUserRepository struct {
+	UserRepository
+	users          map[string]*User
+	settings       map[string]*Settings
+	passwordHashes map[string]string
+	resetTokens    map[string]string
+}
+
+func (r *fakeUserRepository) GetUser(userID string) (*User, error) {
+	user, found := r.users[userID]
+	if !found {
+		return nil, errors.New("user not found")
+	}
+
+	return user, nil
+}
+
+func (r *fakeUserRepository) UpdateProfile(userID string, profile Profile) error {
+	r.users[userID].Email = profile.Email
+	r.users[userID].DisplayName = profile.DisplayName
+	return nil
+}
+
+func (r *fakeUserRepository) UpdateRole(userID string, role Role) error {
+	r.users[userID].Role = role
+	return nil
+}
+
+type fakeOIDCRepository struct {
+	OIDCRepository
+	users      *fakeUserRepository
+	states     map[string]*OIDCLoginState
+	identities map[string]string
+}
+
+func (r *fakeOIDCRepository) CreateLoginState(stateHash string, state *OIDCLoginState, expiresAt time.Time) error {
+	r.states[stateHash] = state
+	return nil
+}
+
+func (r *fakeOIDCRepository) ConsumeLoginState(stateHash string) (*OIDCLoginState, error) {
+	state, found := r.states[stateHash]
+	if !found {
+		return nil, errors.New("OIDC login not found or expired")
+	}
+	delete(r.states, stateHash)
+
+	return state, nil
+}
+
+func (r *fakeOIDCRepository) GetIdentityUser(issuer, subject string) (string, error) {
+	return r.identities[issuer+" "+subject], nil
+}
+
+func (r *fakeOIDCRepository) ProvisionUser(userID string, profile Profile, role Role, issuer, subject string) error {
+	r.users.users[userID] = &User{ID: userID, Email: profile.Email, DisplayName: profile.DisplayName, Role: role}
+	r.identities[issuer+" "+subject] = userID
+	return nil
+}
+
+type oidcTest struct {
+	provider *testProvider
+	handler  Handler
+	sessions *fakeSessionRepository
+	users    *fakeUserRepository
+}
+
+func newOIDCTest(t *testing.T) *oidcTest {
+	t.Helper()
+
+	log, err := logger.NewLogger("error", "")
+	if err != nil {
+		t.Fatalf("error creating logger: %+v", err)
+	}
+
+	provider := newTestProvider(t)
+	sessions := &fakeSessionRepository{sessions: map[string]string{}}
+	users := &fakeUserRepository{users: map[string]*User{}}
+	oidcRepository := &fakeOIDCRepository{
+		users:      users,
+		states:     map[string]*OIDCLoginState{},
+		identities: map[string]string{},
+	}
+
+	handler, err := NewHandler(
+		log,
+		sessions,
+		users,
+		nil,
+		nil,
+		nil,
+		oidcRepository,
+		nil,
+		nil,
+		SessionConfig{IdleTimeout: time.Hour, RememberMeTimeout: time.Hour, MaxLifetime: time.Hour},
+		provider.server.URL,
+		testClientID,
+		"secret",
+		"http://localhost/user/oidc/callback",
+		[]string{"admins"},
+		[]string{"bob"},
+	)
+	if err != nil {
+		t.Fatalf("error creating handler: %+v", err)
+	}
+
+	return &oidcTest{
+		provider: provider,
+		handler:  handler,
+		sessions: sessions,
+		users:    users,
+	}
+}
+
+func (o *oidcTest) expectNoSession(t *testing.T) {
+	t.Helper()
+
+	if len(o.sessions.sessions) != 0 {
+		t.Errorf("expected no session, got %d", len(o.sessions.sessions))
+	}
+}
+
+func TestCompleteOIDCLogin(t *testing.T) {
+	o := newOIDCTest(t)
+
+	authURL, state, err := o.handler.StartOIDCLogin(false)
+	if err != nil {
+		t.Fatalf("error starting login: %+v", err)
+	}
+
+	challenge, nonce := o.provider.authorize(authURL)
+	code := o.provider.issueCode(challenge, nonce, "alice")
+
+	sessionID, _, err := o.handler.CompleteOIDCLogin(state, code, Client{})
+	if err != nil {
+		t.Fatalf("error completing login: %+v", err)
+	}
+
+	if userID := o.sessions.sessions[sessionID]; userID != "alice" {
+		t.Errorf("expected session of 'alice', got '%s'", userID)
+	}
+
+	user, found := o.users.users["alice"]
+	if !found {
+		t.Fatalf("expected user 'alice' to be provisioned")
+	}
+	if user.Email != "alice@example.com" || user.Role != RoleMember {
+		t.Errorf("unexpected provisioned user %+v", user)
+	}
+}
+
+func TestCompleteOIDCLoginRejectsReusedState(t *testing.T) {
+	o := newOIDCTest(t)
+
+	authURL, state, err := o.handler.StartOIDCLogin(false)
+	if err != nil {
+		t.Fatalf("error starting login: %+v", err)
+	}
+
+	challenge, nonce := o.provider.authorize(authURL)
+	if _, _, err := o.handler.CompleteOIDCLogin(state, o.provider.issueCode(challenge, nonce, "alice"), Client{}); err != nil {
+		t.Fatalf("error completing login: %+v", err)
+	}
+
+	if _, _, err := o.handler.CompleteOIDCLogin(state, o.provider.issueCode(challenge, nonce, "alice"), Client{}); err == nil {
+		t.Errorf("expected reused state to be rejected")
+	}
+
+	if len(o.sessions.sessions) != 1 {
+		t.Errorf("expected 1 session, got %d", len(o.sessions.sessions))
+	}
+}
+
+func TestCompleteOIDCLoginRejectsUnknownState(t *testing.T) {
+	o := newOIDCTest(t)
+
+	authURL, _, err := o.handler.StartOIDCLogin(false)
+	if err != nil {
+		t.Fatalf("error starting login: %+v", err)
+	}
+
+	challenge, nonce := o.provider.authorize(authURL)
+	if _, _, err := o.handler.CompleteOIDCLogin("unknown", o.provider.issueCode(challenge, nonce, "alice"), Client{}); err == nil {
+		t.Errorf("expected unknown state to be rejected")
+	}
+
+	o.expectNoSession(t)
+}
+
+func TestCompleteOIDCLoginRejectsNonceMismatch(t *testing.T) {
+	o := newOIDCTest(t)
+
+	authURL, state, err := o.handler.StartOIDCLogin(false)
+	if err != nil {
+		t.Fatalf("error starting login: %+v", err)
+	}
+
+	challenge, _ := o.provider.authorize(authURL)
+	_, _, err = o.handler.CompleteOIDCLogin(state, o.provider.issueCode(challenge, "other-nonce", "alice"), Client{})
+	if err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Errorf("expected nonce mismatch to be rejected, got %+v", err)
+	}
+
+	o.expectNoSession(t)
+}
+
+func TestCompleteOIDCLoginRejectsWrongVerifier(t *testing.T) {
+	o := newOIDCTest(t)
+
+	authURL, state, err := o.handler.StartOIDCLogin(false)
+	if err != nil {
+		t.Fatalf("error starting login: %+v", err)
+	}
+	_, nonce := o.provider.authorize(authURL)
+
+	// the code was issued for another login, e.g. of an attacker, so the
+	// verifier of this login doesn't match its challenge
+	otherAuthURL, _, err := o.handler.StartOIDCLogin(false)
+	if err != nil {
+		t.Fatalf("error starting login: %+v", err)
+	}
+	otherChallenge, _ := o.provider.authorize(otherAuthURL)
+
+	_, _, err = o.handler.CompleteOIDCLogin(state, o.provider.issueCode(otherChallenge, nonce, "alice"), Client{})
+	if err == nil || !strings.Contains(err.Error(), "exchange") {
+		t.Errorf("expected wrong verifier to be rejected, got %+v", err)
+	}
+
+	o.expectNoSession(t)
+}
+
+func TestOIDCLoginKeepsConfiguredAdmins(t *testing.T) {
+	o := newOIDCTest(t)
+
+	login := func(subject string, groups []string) {
+		t.Helper()
+
+		o.provider.mutex.Lock()
+		o.provider.groups[subject] = groups
+		o.provider.mutex.Unlock()
+
+		authURL, state, err := o.handler.StartOIDCLogin(false)
+		if err != nil {
+			t.Fatalf("error starting login: %+v", err)
+		}
+
+		challenge, nonce := o.provider.authorize(authURL)
+		if _, _, err := o.handler.CompleteOIDCLogin(state, o.provider.issueCode(challenge, nonce, subject), Client{}); err != nil {
+			t.Fatalf("error completing login: %+v", err)
+		}
+	}
+
+	login("alice", []string{"admins"})
+	login("bob", nil)
+	if role := o.users.users["bob"].Role; role != RoleAdmin {
+		t.Errorf("expected configured admin to be provisioned as admin, got '%s'", role)
+	}
+
+	login("alice", nil)
+	login("bob", nil)
+	if role := o.users.users["alice"].Role; role != RoleMember {
+		t.Errorf("expected user to be demoted without admin group, got '%s'", role)
+	}
+	if role := o.users.users["bob"].Role; role != RoleAdmin {
+		t.Errorf("expected configured admin to stay admin, got '%s'", role)
+	}
+}
@@ -1,6 +1,7 @@
 package authentification
 
 import (
+	"database/sql"
 	"errors"
 	"fmt"
 	"time"
@@ -10,11 +11,19 @@ import (
 )
 
 type SessionRepository interface {
-	CreateSession(sessionID, userID string, expiresAt time.Time) error
+	CreateSession(sessionID, userID string, client Client, idleTimeout time.Duration, expiresAt, maxExpiresAt time.Time) error
 	DeleteSession(sessionID string) error
 	GetSessionUser(sessionID string) (string, error)
+	GetUserSessions(userID, currentSessionID string) ([]*Session, error)
+	DeleteUserSession(userID string, publicID int) error
+	DeleteUserSessions(userID, exceptSessionID string) error
 }
 
+// lastSeenInterval throttles updating the last-seen time and extending the
+// expiry of a session as well as the last use of access tokens, so that not
+// every request results in a write.
+const lastSeenInterval = 5 * time.Minute
+
 type sessionRepositoryImpl struct {
 	logger   logger.Logger
 	database database.Database
@@ -37,18 +46,24 @@ const (
 	tableSessions           = "sessions"
 	columnSessionsSessionID = "session_id"
 	columnSessionsUserID    = "user_id"
+	columnSessionsPublicID  = "public_id"
+	columnSessionsUserAgent = "user_agent"
+	columnSessionsIP        = "ip"
+	columnSessionsLastSeen  = "last_seen_at"
 	columnExpiresAt         = "expires_at"
+	columnMaxExpiresAt      = "max_expires_at"
+	columnIdleTimeout       = "idle_timeout"
 	columnSessionsCreatedAt = "created_at"
 	columnSessionsUpdatedAt = "updated_at"
 )
 
-func (r *sessionRepositoryImpl) CreateSession(sessionID, userID string, expiresAt time.Time) error {
+func (r *sessionRepositoryImpl) CreateSession(sessionID, userID string, client Client, idleTimeout time.Duration, expiresAt, maxExpiresAt time.Time) error {
 	_, err := r.database.Exec(
 		"INSERT"+
 			" INTO "+tableSessions+
-			" ("+columnSessionsSessionID+", "+columnSessionsUserID+", "+columnExpiresAt+")"+
-			" VALUES ($1, $2, $3);",
-		sessionID, userID, expiresAt)
+			" ("+columnSessionsSessionID+", "+columnSessionsUserID+", "+columnSessionsUserAgent+", "+columnSessionsIP+", "+columnIdleTimeout+", "+columnExpiresAt+", "+columnMaxExpiresAt+")"+
+			" VALUES ($1, $2, $3, $4, $5, $6, $7);",
+		sessionID, userID, client.UserAgent, client.IP, int64(idleTimeout.Seconds()), expiresAt, maxExpiresAt)
 	if err != nil {
 		return r.logger.LogAndAbstractError("database error", "Couldn't create session: %+v", err)
 	}
@@ -72,14 +87,34 @@ func (r *sessionRepositoryImpl) DeleteSession(sessionID string) error {
 	return nil
 }
 
+// DeleteUserSessions deletes all sessions of the user except the given one.
+func (r *sessionRepositoryImpl) DeleteUserSessions(userID, exceptSessionID string) error {
+	if _, err := r.database.Exec(
+		"DELETE"+
+			" FROM "+tableSessions+
+			" WHERE "+columnSessionsUserID+"=$1 AND "+columnSessionsSessionID+"<>$2;",
+		userID, exceptSessionID,
+	); err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't delete sessions: %+v", err)
+	}
+
+	return nil
+}
+
+// GetSessionUser returns the user of the unexpired session. The session is
+// recorded as seen just now and its expiry is extended by the idle timeout.
 func (r *sessionRepositoryImpl) GetSessionUser(sessionID string) (string, error) {
-	var userID string
+	var (
+		userID     string
+		lastSeenAt time.Time
+	)
 	if err := r.database.QueryRow(
-		"SELECT "+columnSessionsUserID+
+		"SELECT "+columnSessionsUserID+", "+columnSessionsLastSeen+
 			" FROM "+tableSessions+
-			" WHERE "+columnSessionsSessionID+"=$1 AND "+columnExpiresAt+">NOW();",
+			" WHERE "+columnSessionsSessionID+"=$1 AND "+columnExpiresAt+">NOW()"+
+			" AND "+columnSessionsUserID+" IN (SELECT "+columnUserID+" FROM "+tableUsers+" WHERE "+columnDisabled+"=FALSE);",
 		[]any{sessionID},
-		&userID,
+		&userID, &lastSeenAt,
 	); err != nil {
 		switch {
 		case errors.As(err, &database.NoRowsError{}):
@@ -89,9 +124,89 @@ func (r *sessionRepositoryImpl) GetSessionUser(sessionID string) (string, error)
 		}
 	}
 
+	if time.Since(lastSeenAt) > lastSeenInterval {
+		if _, err := r.database.Exec(
+			"UPDATE "+tableSessions+
+				" SET "+columnSessionsLastSeen+"=NOW()"+
+				", "+columnExpiresAt+"=LEAST(NOW()+"+columnIdleTimeout+"*INTERVAL '1 second', "+columnMaxExpiresAt+")"+
+				" WHERE "+columnSessionsSessionID+"=$1;",
+			sessionID,
+		); err != nil {
+			r.logger.Error("Couldn't extend session: %+v", err)
+		}
+	}
+
 	return userID, nil
 }
 
+// GetUserSessions returns the unexpired sessions of the user, starting with
+// the most recently seen.
+func (r *sessionRepositoryImpl) GetUserSessions(userID, currentSessionID string) ([]*Session, error) {
+	rows, err := r.database.Query(
+		"SELECT "+columnSessionsPublicID+", "+columnSessionsUserAgent+", "+columnSessionsIP+
+			", "+columnSessionsSessionID+"=$2"+
+			", "+columnSessionsLastSeen+", "+columnExpiresAt+", "+columnSessionsCreatedAt+
+			" FROM "+tableSessions+
+			" WHERE "+columnSessionsUserID+"=$1 AND "+columnExpiresAt+">NOW()"+
+			" ORDER BY "+columnSessionsLastSeen+" DESC;",
+		userID, currentSessionID,
+	)
+	if err != nil {
+		return nil, r.logger.LogAndAbstractError("database error", "Error getting sessions: %+v", err)
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		var (
+			session   Session
+			userAgent sql.NullString
+			ip        sql.NullString
+		)
+		if err := rows.Scan(
+			&session.ID,
+			&userAgent,
+			&ip,
+			&session.Current,
+			&session.LastSeenAt,
+			&session.ExpiresAt,
+			&session.CreatedAt,
+		); err != nil {
+			return nil, r.logger.LogAndAbstractError("database error", "Error scanning session: %+v", err)
+		}
+		session.UserAgent = userAgent.String
+		session.IP = ip.String
+
+		sessions = append(sessions, &session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, r.logger.LogAndAbstractError("database error", "Error iterating sessions: %+v", err)
+	}
+
+	return sessions, nil
+}
+
+// DeleteUserSession deletes the session with the public ID, if it belongs to
+// the user.
+func (r *sessionRepositoryImpl) DeleteUserSession(userID string, publicID int) error {
+	res, err := r.database.Exec(
+		"DELETE"+
+			" FROM "+tableSessions+
+			" WHERE "+columnSessionsUserID+"=$1 AND "+columnSessionsPublicID+"=$2;",
+		userID, publicID,
+	)
+	if err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't delete session: %+v", err)
+	}
+
+	if rows, _ := res.RowsAffected(); rows != 1 {
+		return fmt.Errorf("session '%d' not found", publicID)
+	}
+
+	return nil
+}
+
 func (r *sessionRepositoryImpl) CleanUpSessions() {
 	if _, err := r.database.Exec(
 		"DELETE" +
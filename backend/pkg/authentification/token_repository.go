@@ -0,0 +1,210 @@
+package authentification
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/DominikKuenkele/TimeTrack/libraries/database"
+	"github.com/DominikKuenkele/TimeTrack/libraries/logger"
+	"github.com/DominikKuenkele/TimeTrack/libraries/utilitites"
+	"github.com/lib/pq"
+)
+
+type TokenRepository interface {
+	CreateToken(userID, name, tokenHash string, scopes []Scope, expiresAt *time.Time) (*AccessToken, error)
+	GetTokens(userID string) ([]*AccessToken, error)
+	DeleteToken(userID string, tokenID int) error
+	DeleteUserTokens(userID string) error
+	UseToken(tokenHash string) (*AccessToken, error)
+}
+
+type tokenRepositoryImpl struct {
+	logger   logger.Logger
+	database database.Database
+}
+
+var _ TokenRepository = &tokenRepositoryImpl{}
+
+func NewTokenRepository(logger logger.Logger, database database.Database) (TokenRepository, error) {
+	if database == nil {
+		return nil, errors.New("database must not be nil")
+	}
+
+	return &tokenRepositoryImpl{
+		logger:   logger,
+		database: database,
+	}, nil
+}
+
+const (
+	tableAccessTokens            = "access_tokens"
+	columnAccessTokensTokenID    = "token_id"
+	columnAccessTokensUserID     = "user_id"
+	columnAccessTokensName       = "name"
+	columnAccessTokensTokenHash  = "token_hash"
+	columnAccessTokensScopes     = "scopes"
+	columnAccessTokensExpiresAt  = "expires_at"
+	columnAccessTokensLastUsedAt = "last_used_at"
+	columnAccessTokensCreatedAt  = "created_at"
+
+	selectAccessToken = columnAccessTokensTokenID + ", " + columnAccessTokensUserID + ", " + columnAccessTokensName + ", " + columnAccessTokensScopes + ", " + columnAccessTokensExpiresAt + ", " + columnAccessTokensLastUsedAt + ", " + columnAccessTokensCreatedAt
+)
+
+// dbAccessToken holds the destinations for scanning a row of selectAccessToken.
+type dbAccessToken struct {
+	token      AccessToken
+	scopes     []string
+	expiresAt  sql.NullTime
+	lastUsedAt sql.NullTime
+}
+
+func (t *dbAccessToken) dest() []any {
+	return []any{
+		&t.token.ID,
+		&t.token.UserID,
+		&t.token.Name,
+		pq.Array(&t.scopes),
+		&t.expiresAt,
+		&t.lastUsedAt,
+		&t.token.CreatedAt,
+	}
+}
+
+func (t *dbAccessToken) toDomain() *AccessToken {
+	token := t.token
+	for _, scope := range t.scopes {
+		token.Scopes = append(token.Scopes, Scope(scope))
+	}
+
+	if t.expiresAt.Valid {
+		token.ExpiresAt = &t.expiresAt.Time
+	}
+
+	if t.lastUsedAt.Valid {
+		token.LastUsedAt = &t.lastUsedAt.Time
+	}
+
+	return &token
+}
+
+func (r *tokenRepositoryImpl) CreateToken(userID, name, tokenHash string, scopes []Scope, expiresAt *time.Time) (*AccessToken, error) {
+	token := &dbAccessToken{}
+	if err := r.database.QueryRow(
+		"INSERT"+
+			" INTO "+tableAccessTokens+
+			" ("+columnAccessTokensUserID+", "+columnAccessTokensName+", "+columnAccessTokensTokenHash+", "+columnAccessTokensScopes+", "+columnAccessTokensExpiresAt+")"+
+			" VALUES ($1, $2, $3, $4, $5)"+
+			" RETURNING "+selectAccessToken+";",
+		[]any{userID, name, tokenHash, pq.Array(utilitites.Transform(scopes, func(s Scope) string { return string(s) })), expiresAt},
+		token.dest()...,
+	); err != nil {
+		switch {
+		case errors.As(err, &database.DuplicateError{}):
+			return nil, fmt.Errorf("token '%s' already exists", name)
+		default:
+			return nil, r.logger.LogAndAbstractError("database error", "Couldn't create access token: %+v", err)
+		}
+	}
+
+	return token.toDomain(), nil
+}
+
+func (r *tokenRepositoryImpl) GetTokens(userID string) ([]*AccessToken, error) {
+	rows, err := r.database.Query(
+		"SELECT "+selectAccessToken+
+			" FROM "+tableAccessTokens+
+			" WHERE "+columnAccessTokensUserID+"=$1"+
+			" ORDER BY "+columnAccessTokensCreatedAt+" ASC;",
+		userID,
+	)
+	if err != nil {
+		return nil, r.logger.LogAndAbstractError("database error", "Error getting access tokens: %+v", err)
+	}
+	defer rows.Close()
+
+	tokens := []*AccessToken{}
+	for rows.Next() {
+		token := &dbAccessToken{}
+		if err := rows.Scan(token.dest()...); err != nil {
+			return nil, r.logger.LogAndAbstractError("database error", "Error scanning access token: %+v", err)
+		}
+
+		tokens = append(tokens, token.toDomain())
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, r.logger.LogAndAbstractError("database error", "Error iterating access tokens: %+v", err)
+	}
+
+	return tokens, nil
+}
+
+func (r *tokenRepositoryImpl) DeleteToken(userID string, tokenID int) error {
+	res, err := r.database.Exec(
+		"DELETE"+
+			" FROM "+tableAccessTokens+
+			" WHERE "+columnAccessTokensUserID+"=$1 AND "+columnAccessTokensTokenID+"=$2;",
+		userID, tokenID,
+	)
+	if err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't delete access token: %+v", err)
+	}
+
+	if rows, _ := res.RowsAffected(); rows != 1 {
+		return fmt.Errorf("token '%d' not found", tokenID)
+	}
+
+	return nil
+}
+
+// DeleteUserTokens revokes all tokens of the user.
+func (r *tokenRepositoryImpl) DeleteUserTokens(userID string) error {
+	if _, err := r.database.Exec(
+		"DELETE"+
+			" FROM "+tableAccessTokens+
+			" WHERE "+columnAccessTokensUserID+"=$1;",
+		userID,
+	); err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't delete access tokens of user '%s': %+v", userID, err)
+	}
+
+	return nil
+}
+
+// UseToken returns the unexpired token with the hash and records, that it was
+// used just now. Tokens of disabled users can't be used.
+func (r *tokenRepositoryImpl) UseToken(tokenHash string) (*AccessToken, error) {
+	token := &dbAccessToken{}
+	if err := r.database.QueryRow(
+		"SELECT "+selectAccessToken+
+			" FROM "+tableAccessTokens+
+			" WHERE "+columnAccessTokensTokenHash+"=$1"+
+			" AND ("+columnAccessTokensExpiresAt+" IS NULL OR "+columnAccessTokensExpiresAt+">NOW())"+
+			" AND "+columnAccessTokensUserID+" IN (SELECT "+columnUserID+" FROM "+tableUsers+" WHERE "+columnDisabled+"=FALSE);",
+		[]any{tokenHash},
+		token.dest()...,
+	); err != nil {
+		switch {
+		case errors.As(err, &database.NoRowsError{}):
+			return nil, errors.New("access token not found")
+		default:
+			return nil, r.logger.LogAndAbstractError("database error", "Error scanning access token: %+v", err)
+		}
+	}
+
+	accessToken := token.toDomain()
+	if accessToken.LastUsedAt == nil || time.Since(*accessToken.LastUsedAt) > lastSeenInterval {
+		if _, err := r.database.Exec(
+			"UPDATE "+tableAccessTokens+
+				" SET "+columnAccessTokensLastUsedAt+"=NOW()"+
+				" WHERE "+columnAccessTokensTokenID+"=$1;",
+			accessToken.ID,
+		); err != nil {
+			r.logger.Error("Couldn't record use of access token: %+v", err)
+		}
+	}
+
+	return accessToken, nil
+}
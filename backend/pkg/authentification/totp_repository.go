@@ -0,0 +1,281 @@
+package authentification
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/DominikKuenkele/TimeTrack/libraries/database"
+	"github.com/DominikKuenkele/TimeTrack/libraries/logger"
+)
+
+type TOTPRepository interface {
+	SaveSecret(userID, secret string) error
+	GetSecret(userID string) (*TOTPSecret, error)
+	ConfirmSecret(userID string, step int64, recoveryCodeHashes []string) error
+	UseStep(userID string, step int64) error
+	UseRecoveryCode(userID, codeHash string) error
+	DeleteSecret(userID string) error
+	CreateChallenge(challengeHash, userID string, expiresAt time.Time) error
+	GetChallengeUser(challengeHash string) (string, error)
+	DeleteChallenge(challengeHash string) error
+}
+
+type totpRepositoryImpl struct {
+	logger   logger.Logger
+	database database.Database
+}
+
+var _ TOTPRepository = &totpRepositoryImpl{}
+
+func NewTOTPRepository(logger logger.Logger, database database.Database) (TOTPRepository, error) {
+	if database == nil {
+		return nil, errors.New("database must not be nil")
+	}
+
+	return &totpRepositoryImpl{
+		logger:   logger,
+		database: database,
+	}, nil
+}
+
+const (
+	tableTOTPSecrets              = "totp_secrets"
+	columnTOTPSecretsUserID       = "user_id"
+	columnTOTPSecretsSecret       = "secret"
+	columnTOTPSecretsConfirmed    = "confirmed"
+	columnTOTPSecretsLastUsedStep = "last_used_step"
+
+	tableRecoveryCodes          = "totp_recovery_codes"
+	columnRecoveryCodesUserID   = "user_id"
+	columnRecoveryCodesCodeHash = "code_hash"
+
+	tableLoginChallenges               = "login_challenges"
+	columnLoginChallengesChallengeHash = "challenge_hash"
+	columnLoginChallengesUserID        = "user_id"
+	columnLoginChallengesExpiresAt     = "expires_at"
+)
+
+// SaveSecret stores a new, unconfirmed secret. A confirmed secret isn't
+// replaced.
+func (r *totpRepositoryImpl) SaveSecret(userID, secret string) error {
+	res, err := r.database.Exec(
+		"INSERT"+
+			" INTO "+tableTOTPSecrets+
+			" ("+columnTOTPSecretsUserID+", "+columnTOTPSecretsSecret+")"+
+			" VALUES ($1, $2)"+
+			" ON CONFLICT ("+columnTOTPSecretsUserID+") DO UPDATE"+
+			" SET "+columnTOTPSecretsSecret+"=EXCLUDED."+columnTOTPSecretsSecret+
+			", "+columnTOTPSecretsLastUsedStep+"=NULL"+
+			" WHERE "+tableTOTPSecrets+"."+columnTOTPSecretsConfirmed+"=FALSE;",
+		userID, secret,
+	)
+	if err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't save TOTP secret: %+v", err)
+	}
+
+	if rows, _ := res.RowsAffected(); rows != 1 {
+		return errors.New("two-factor authentication is already enabled")
+	}
+
+	return nil
+}
+
+// GetSecret returns the secret of the user or nil, if the user didn't enrol.
+func (r *totpRepositoryImpl) GetSecret(userID string) (*TOTPSecret, error) {
+	var (
+		secret       TOTPSecret
+		lastUsedStep sql.NullInt64
+	)
+	if err := r.database.QueryRow(
+		"SELECT "+columnTOTPSecretsSecret+", "+columnTOTPSecretsConfirmed+", "+columnTOTPSecretsLastUsedStep+
+			" FROM "+tableTOTPSecrets+
+			" WHERE "+columnTOTPSecretsUserID+"=$1;",
+		[]any{userID},
+		&secret.Secret, &secret.Confirmed, &lastUsedStep,
+	); err != nil {
+		switch {
+		case errors.As(err, &database.NoRowsError{}):
+			return nil, nil
+		default:
+			return nil, r.logger.LogAndAbstractError("database error", "Error scanning TOTP secret: %+v", err)
+		}
+	}
+
+	if lastUsedStep.Valid {
+		secret.LastUsedStep = &lastUsedStep.Int64
+	}
+
+	return &secret, nil
+}
+
+// ConfirmSecret enables two-factor authentication after the first valid code
+// and replaces the recovery codes.
+func (r *totpRepositoryImpl) ConfirmSecret(userID string, step int64, recoveryCodeHashes []string) error {
+	tx, err := r.database.Begin()
+	if err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't create transaction: %+v", err)
+	}
+	defer tx.Rollback()
+
+	res, err := r.database.ExecWithTx(
+		tx,
+		"UPDATE "+tableTOTPSecrets+
+			" SET "+columnTOTPSecretsConfirmed+"=TRUE, "+columnTOTPSecretsLastUsedStep+"=$2"+
+			" WHERE "+columnTOTPSecretsUserID+"=$1 AND "+columnTOTPSecretsConfirmed+"=FALSE;",
+		userID, step,
+	)
+	if err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't confirm TOTP secret: %+v", err)
+	}
+
+	if rows, _ := res.RowsAffected(); rows != 1 {
+		return errors.New("no pending two-factor enrolment")
+	}
+
+	if _, err := r.database.ExecWithTx(
+		tx,
+		"DELETE"+
+			" FROM "+tableRecoveryCodes+
+			" WHERE "+columnRecoveryCodesUserID+"=$1;",
+		userID,
+	); err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't delete recovery codes: %+v", err)
+	}
+
+	for _, codeHash := range recoveryCodeHashes {
+		if _, err := r.database.ExecWithTx(
+			tx,
+			"INSERT"+
+				" INTO "+tableRecoveryCodes+
+				" ("+columnRecoveryCodesUserID+", "+columnRecoveryCodesCodeHash+")"+
+				" VALUES ($1, $2);",
+			userID, codeHash,
+		); err != nil {
+			return r.logger.LogAndAbstractError("database error", "Couldn't create recovery code: %+v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't commit transaction: %+v", err)
+	}
+
+	return nil
+}
+
+// UseStep records the time step of a valid code. It fails, if a code of the
+// step or a later one was already used.
+func (r *totpRepositoryImpl) UseStep(userID string, step int64) error {
+	res, err := r.database.Exec(
+		"UPDATE "+tableTOTPSecrets+
+			" SET "+columnTOTPSecretsLastUsedStep+"=$2"+
+			" WHERE "+columnTOTPSecretsUserID+"=$1 AND "+columnTOTPSecretsConfirmed+"=TRUE"+
+			" AND ("+columnTOTPSecretsLastUsedStep+" IS NULL OR "+columnTOTPSecretsLastUsedStep+"<$2);",
+		userID, step,
+	)
+	if err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't use TOTP code: %+v", err)
+	}
+
+	if rows, _ := res.RowsAffected(); rows != 1 {
+		return errors.New("code was already used")
+	}
+
+	return nil
+}
+
+// UseRecoveryCode deletes the recovery code, so that it can only be used once.
+func (r *totpRepositoryImpl) UseRecoveryCode(userID, codeHash string) error {
+	res, err := r.database.Exec(
+		"DELETE"+
+			" FROM "+tableRecoveryCodes+
+			" WHERE "+columnRecoveryCodesUserID+"=$1 AND "+columnRecoveryCodesCodeHash+"=$2;",
+		userID, codeHash,
+	)
+	if err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't use recovery code: %+v", err)
+	}
+
+	if rows, _ := res.RowsAffected(); rows != 1 {
+		return errors.New("invalid code")
+	}
+
+	return nil
+}
+
+// DeleteSecret disables two-factor authentication. The recovery codes are
+// deleted with the secret.
+func (r *totpRepositoryImpl) DeleteSecret(userID string) error {
+	res, err := r.database.Exec(
+		"DELETE"+
+			" FROM "+tableTOTPSecrets+
+			" WHERE "+columnTOTPSecretsUserID+"=$1;",
+		userID,
+	)
+	if err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't delete TOTP secret: %+v", err)
+	}
+
+	if rows, _ := res.RowsAffected(); rows != 1 {
+		return errors.New("two-factor authentication is not enabled")
+	}
+
+	return nil
+}
+
+func (r *totpRepositoryImpl) CreateChallenge(challengeHash, userID string, expiresAt time.Time) error {
+	if _, err := r.database.Exec(
+		"INSERT"+
+			" INTO "+tableLoginChallenges+
+			" ("+columnLoginChallengesChallengeHash+", "+columnLoginChallengesUserID+", "+columnLoginChallengesExpiresAt+")"+
+			" VALUES ($1, $2, $3);",
+		challengeHash, userID, expiresAt,
+	); err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't create login challenge: %+v", err)
+	}
+
+	// expired challenges are removed whenever a new one is created
+	if _, err := r.database.Exec(
+		"DELETE" +
+			" FROM " + tableLoginChallenges +
+			" WHERE " + columnLoginChallengesExpiresAt + "<NOW();",
+	); err != nil {
+		r.logger.Error("Error while cleaning up login challenges: %+v", err)
+	}
+
+	return nil
+}
+
+// GetChallengeUser returns the user of the unexpired challenge.
+func (r *totpRepositoryImpl) GetChallengeUser(challengeHash string) (string, error) {
+	var userID string
+	if err := r.database.QueryRow(
+		"SELECT "+columnLoginChallengesUserID+
+			" FROM "+tableLoginChallenges+
+			" WHERE "+columnLoginChallengesChallengeHash+"=$1 AND "+columnLoginChallengesExpiresAt+">NOW();",
+		[]any{challengeHash},
+		&userID,
+	); err != nil {
+		switch {
+		case errors.As(err, &database.NoRowsError{}):
+			return "", errors.New("login challenge not found or expired")
+		default:
+			return "", r.logger.LogAndAbstractError("database error", "Error scanning login challenge: %+v", err)
+		}
+	}
+
+	return userID, nil
+}
+
+func (r *totpRepositoryImpl) DeleteChallenge(challengeHash string) error {
+	if _, err := r.database.Exec(
+		"DELETE"+
+			" FROM "+tableLoginChallenges+
+			" WHERE "+columnLoginChallengesChallengeHash+"=$1;",
+		challengeHash,
+	); err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't delete login challenge: %+v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package authentification
+
+import (
+	"testing"
+
+	"github.com/DominikKuenkele/TimeTrack/libraries/database/databasetest"
+	"github.com/DominikKuenkele/TimeTrack/libraries/totp"
+)
+
+func TestUseStepRejectsReplay(t *testing.T) {
+	db, log := databasetest.NewDatabase(t)
+	userID := databasetest.CreateUser(t, db)
+
+	repository, err := NewTOTPRepository(log, db)
+	if err != nil {
+		t.Fatalf("error creating repository: %+v", err)
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatalf("error generating secret: %+v", err)
+	}
+	if err := repository.SaveSecret(userID, secret); err != nil {
+		t.Fatalf("error saving secret: %+v", err)
+	}
+	if err := repository.ConfirmSecret(userID, 100, nil); err != nil {
+		t.Fatalf("error confirming secret: %+v", err)
+	}
+
+	tests := []struct {
+		name  string
+		step  int64
+		valid bool
+	}{
+		{"step of the confirmation", 100, false},
+		{"next step", 101, true},
+		{"same step again", 101, false},
+		{"earlier step", 100, false},
+		{"later step", 102, true},
+	}
+
+	for _, test := range tests {
+		err := repository.UseStep(userID, test.step)
+		switch {
+		case test.valid && err != nil:
+			t.Errorf("%s: expected step %d to be accepted, got %+v", test.name, test.step, err)
+		case !test.valid && err == nil:
+			t.Errorf("%s: expected step %d to be rejected", test.name, test.step)
+		}
+	}
+}
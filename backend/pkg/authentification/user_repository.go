@@ -1,16 +1,33 @@
 package authentification
 
 import (
+	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/DominikKuenkele/TimeTrack/libraries/database"
 	"github.com/DominikKuenkele/TimeTrack/libraries/logger"
+	"github.com/DominikKuenkele/TimeTrack/libraries/rounding"
+	"github.com/lib/pq"
 )
 
 type UserRepository interface {
-	CreateUser(userID, passwordHash string) error
+	CreateUser(userID, passwordHash string, role Role) error
+	GetUser(userID string) (*User, error)
+	GetUsers() ([]*User, error)
+	GetUsage(userID string) (*Usage, error)
+	GrantRole(userIDs []string, role Role) error
+	SetDisabled(userID string, disabled bool) error
+	DeleteUser(userID string) error
 	GetPasswordHash(userID string) (string, error)
+	UpdatePasswordHash(userID, passwordHash string) error
+	UpdateProfile(userID string, profile Profile) error
+	UpdateRole(userID string, role Role) error
+	CreatePasswordReset(userID, tokenHash string, expiresAt time.Time) error
+	ConsumePasswordReset(tokenHash string) (string, error)
+	GetSettings(userID string) (*Settings, error)
+	UpdateSettings(userID string, settings *Settings) error
 }
 
 type userRepositoryImpl struct {
@@ -32,29 +49,218 @@ func NewUserRepository(logger logger.Logger, database database.Database) (UserRe
 }
 
 const (
-	tableUsers           = "users"
-	columnUserID         = "user_id"
-	columnHashedPassword = "hashed_password"
-	columnCreatedAt      = "created_at"
-	columnUpdatedAt      = "updated_at"
+	tableUsers              = "users"
+	columnUserID            = "user_id"
+	columnHashedPassword    = "hashed_password"
+	columnEmail             = "email"
+	columnDisplayName       = "display_name"
+	columnRole              = "role"
+	columnDisabled          = "disabled"
+	columnTimeZone          = "time_zone"
+	columnMaxTimerDuration  = "max_timer_duration"
+	columnRoundingMode      = "rounding_mode"
+	columnRoundingIncrement = "rounding_increment"
+	columnRoundingMinimum   = "rounding_minimum"
+	columnCreatedAt         = "created_at"
+	columnUpdatedAt         = "updated_at"
+
+	tablePasswordResets           = "password_resets"
+	columnPasswordResetsTokenHash = "token_hash"
+	columnPasswordResetsUserID    = "user_id"
+	columnPasswordResetsExpiresAt = "expires_at"
+
+	// the tracked data is only read for the usage statistics
+	tableProjects             = "projects"
+	columnProjectsUserID      = "user_id"
+	tableActivities           = "activities"
+	columnActivitiesUserID    = "user_id"
+	columnActivitiesStartedAt = "started_at"
+	columnActivitiesEndedAt   = "ended_at"
+
+	selectUser = columnUserID + ", " + columnEmail + ", " + columnDisplayName + ", " + columnRole + ", " + columnDisabled + ", " + columnHashedPassword + " IS NOT NULL, " + columnCreatedAt
 )
 
-func (u *userRepositoryImpl) CreateUser(userID, hashedPassword string) error {
+// dbUser holds the destinations for scanning a row of selectUser.
+type dbUser struct {
+	user        User
+	email       sql.NullString
+	displayName sql.NullString
+}
+
+func (u *dbUser) dest() []any {
+	return []any{
+		&u.user.ID,
+		&u.email,
+		&u.displayName,
+		&u.user.Role,
+		&u.user.Disabled,
+		&u.user.HasPassword,
+		&u.user.CreatedAt,
+	}
+}
+
+func (u *dbUser) toDomain() *User {
+	user := u.user
+	user.Email = u.email.String
+	user.DisplayName = u.displayName.String
+
+	return &user
+}
+
+func (u *userRepositoryImpl) CreateUser(userID, hashedPassword string, role Role) error {
 	_, err := u.database.Exec(
 		"INSERT"+
 			" INTO "+tableUsers+
-			" ("+columnUserID+", "+columnHashedPassword+")"+
-			" VALUES ($1, $2);",
-		userID, hashedPassword)
+			" ("+columnUserID+", "+columnHashedPassword+", "+columnRole+")"+
+			" VALUES ($1, $2, $3);",
+		userID, hashedPassword, role)
+	if err != nil {
+		switch {
+		case errors.As(err, &database.DuplicateError{}):
+			return fmt.Errorf("user '%s' already exists", userID)
+		default:
+			return u.logger.LogAndAbstractError("database error", "Couldn't create user: %+v", err)
+		}
+	}
+
+	return nil
+}
+
+func (u *userRepositoryImpl) GetUser(userID string) (*User, error) {
+	user := &dbUser{}
+	if err := u.database.QueryRow(
+		"SELECT "+selectUser+
+			" FROM "+tableUsers+
+			" WHERE "+columnUserID+"=$1;",
+		[]any{userID},
+		user.dest()...,
+	); err != nil {
+		switch {
+		case errors.As(err, &database.NoRowsError{}):
+			return nil, fmt.Errorf("user '%s' not found", userID)
+		default:
+			return nil, u.logger.LogAndAbstractError("database error", "Error scanning user: %+v", err)
+		}
+	}
+
+	return user.toDomain(), nil
+}
+
+func (u *userRepositoryImpl) GetUsers() ([]*User, error) {
+	rows, err := u.database.Query(
+		"SELECT " + selectUser +
+			" FROM " + tableUsers +
+			" ORDER BY " + columnUserID + " ASC;",
+	)
+	if err != nil {
+		return nil, u.logger.LogAndAbstractError("database error", "Error getting users: %+v", err)
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		user := &dbUser{}
+		if err := rows.Scan(user.dest()...); err != nil {
+			return nil, u.logger.LogAndAbstractError("database error", "Error scanning user: %+v", err)
+		}
+
+		users = append(users, user.toDomain())
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, u.logger.LogAndAbstractError("database error", "Error iterating users: %+v", err)
+	}
+
+	return users, nil
+}
+
+// GetUsage counts the tracked data of the user. Running activities count up
+// to now.
+func (u *userRepositoryImpl) GetUsage(userID string) (*Usage, error) {
+	var (
+		usage          Usage
+		lastActivityAt sql.NullTime
+	)
+	if err := u.database.QueryRow(
+		"SELECT"+
+			" (SELECT COUNT(*) FROM "+tableProjects+" WHERE "+columnProjectsUserID+"=$1)"+
+			", (SELECT COUNT(*) FROM "+tableActivities+" WHERE "+columnActivitiesUserID+"=$1)"+
+			", (SELECT COALESCE(SUM(EXTRACT(EPOCH FROM COALESCE("+columnActivitiesEndedAt+", NOW())-"+columnActivitiesStartedAt+")), 0)::BIGINT"+
+			" FROM "+tableActivities+" WHERE "+columnActivitiesUserID+"=$1)"+
+			", (SELECT MAX("+columnActivitiesStartedAt+") FROM "+tableActivities+" WHERE "+columnActivitiesUserID+"=$1)"+
+			", (SELECT COUNT(*) FROM "+tableSessions+" WHERE "+columnSessionsUserID+"=$1 AND "+columnExpiresAt+">NOW())"+
+			", (SELECT COUNT(*) FROM "+tableAccessTokens+" WHERE "+columnAccessTokensUserID+"=$1);",
+		[]any{userID},
+		&usage.Projects,
+		&usage.Activities,
+		&usage.TrackedSeconds,
+		&lastActivityAt,
+		&usage.ActiveSessions,
+		&usage.AccessTokens,
+	); err != nil {
+		return nil, u.logger.LogAndAbstractError("database error", "Error scanning usage: %+v", err)
+	}
+
+	if lastActivityAt.Valid {
+		usage.LastActivityAt = &lastActivityAt.Time
+	}
+
+	return &usage, nil
+}
+
+// GrantRole sets the role of all given users, which exist.
+func (u *userRepositoryImpl) GrantRole(userIDs []string, role Role) error {
+	if _, err := u.database.Exec(
+		"UPDATE "+tableUsers+
+			" SET "+columnRole+"=$2"+
+			" WHERE "+columnUserID+"=ANY($1);",
+		pq.Array(userIDs), role,
+	); err != nil {
+		return u.logger.LogAndAbstractError("database error", "Couldn't grant role: %+v", err)
+	}
+
+	return nil
+}
+
+func (u *userRepositoryImpl) SetDisabled(userID string, disabled bool) error {
+	res, err := u.database.Exec(
+		"UPDATE "+tableUsers+
+			" SET "+columnDisabled+"=$2"+
+			" WHERE "+columnUserID+"=$1;",
+		userID, disabled)
+	if err != nil {
+		return u.logger.LogAndAbstractError("database error", "Couldn't update user: %+v", err)
+	}
+
+	if rows, _ := res.RowsAffected(); rows != 1 {
+		return fmt.Errorf("user '%s' not found", userID)
+	}
+
+	return nil
+}
+
+// DeleteUser deletes the user together with all tracked data.
+func (u *userRepositoryImpl) DeleteUser(userID string) error {
+	res, err := u.database.Exec(
+		"DELETE"+
+			" FROM "+tableUsers+
+			" WHERE "+columnUserID+"=$1;",
+		userID)
 	if err != nil {
-		return u.logger.LogAndAbstractError("database error", "Couldn't create user: %+v", err)
+		return u.logger.LogAndAbstractError("database error", "Couldn't delete user: %+v", err)
+	}
+
+	if rows, _ := res.RowsAffected(); rows != 1 {
+		return fmt.Errorf("user '%s' not found", userID)
 	}
 
 	return nil
 }
 
+// GetPasswordHash returns the password hash of the user. Users, which were
+// provisioned via OIDC, don't have a password.
 func (u *userRepositoryImpl) GetPasswordHash(userID string) (string, error) {
-	var passwordHash string
+	var passwordHash sql.NullString
 	if err := u.database.QueryRow(
 		"SELECT "+columnHashedPassword+
 			" FROM "+tableUsers+
@@ -70,5 +276,143 @@ func (u *userRepositoryImpl) GetPasswordHash(userID string) (string, error) {
 		}
 	}
 
-	return passwordHash, nil
+	if !passwordHash.Valid {
+		return "", fmt.Errorf("user '%s' has no password", userID)
+	}
+
+	return passwordHash.String, nil
+}
+
+func (u *userRepositoryImpl) UpdatePasswordHash(userID, passwordHash string) error {
+	res, err := u.database.Exec(
+		"UPDATE "+tableUsers+
+			" SET "+columnHashedPassword+"=$2"+
+			" WHERE "+columnUserID+"=$1;",
+		userID, passwordHash)
+	if err != nil {
+		return u.logger.LogAndAbstractError("database error", "Couldn't update password: %+v", err)
+	}
+
+	if rows, _ := res.RowsAffected(); rows != 1 {
+		return fmt.Errorf("user '%s' not found", userID)
+	}
+
+	return nil
+}
+
+func (u *userRepositoryImpl) UpdateProfile(userID string, profile Profile) error {
+	if _, err := u.database.Exec(
+		"UPDATE "+tableUsers+
+			" SET "+columnEmail+"=NULLIF($2, ''), "+columnDisplayName+"=NULLIF($3, '')"+
+			" WHERE "+columnUserID+"=$1;",
+		userID, profile.Email, profile.DisplayName,
+	); err != nil {
+		return u.logger.LogAndAbstractError("database error", "Couldn't update profile: %+v", err)
+	}
+
+	return nil
+}
+
+func (u *userRepositoryImpl) UpdateRole(userID string, role Role) error {
+	res, err := u.database.Exec(
+		"UPDATE "+tableUsers+
+			" SET "+columnRole+"=$2"+
+			" WHERE "+columnUserID+"=$1;",
+		userID, role)
+	if err != nil {
+		return u.logger.LogAndAbstractError("database error", "Couldn't update role: %+v", err)
+	}
+
+	if rows, _ := res.RowsAffected(); rows != 1 {
+		return fmt.Errorf("user '%s' not found", userID)
+	}
+
+	return nil
+}
+
+func (u *userRepositoryImpl) CreatePasswordReset(userID, tokenHash string, expiresAt time.Time) error {
+	if _, err := u.database.Exec(
+		"INSERT"+
+			" INTO "+tablePasswordResets+
+			" ("+columnPasswordResetsTokenHash+", "+columnPasswordResetsUserID+", "+columnPasswordResetsExpiresAt+")"+
+			" VALUES ($1, $2, $3);",
+		tokenHash, userID, expiresAt,
+	); err != nil {
+		return u.logger.LogAndAbstractError("database error", "Couldn't create password reset: %+v", err)
+	}
+
+	return nil
+}
+
+// ConsumePasswordReset deletes the unexpired reset token, so that it can only
+// be used once, and returns the user it was issued for.
+func (u *userRepositoryImpl) ConsumePasswordReset(tokenHash string) (string, error) {
+	var userID string
+	if err := u.database.QueryRow(
+		"DELETE"+
+			" FROM "+tablePasswordResets+
+			" WHERE "+columnPasswordResetsTokenHash+"=$1 AND "+columnPasswordResetsExpiresAt+">NOW()"+
+			" RETURNING "+columnPasswordResetsUserID+";",
+		[]any{tokenHash},
+		&userID,
+	); err != nil {
+		switch {
+		case errors.As(err, &database.NoRowsError{}):
+			return "", errors.New("invalid or expired reset token")
+		default:
+			return "", u.logger.LogAndAbstractError("database error", "Couldn't consume password reset: %+v", err)
+		}
+	}
+
+	return userID, nil
+}
+
+func (u *userRepositoryImpl) GetSettings(userID string) (*Settings, error) {
+	var (
+		settings          = &Settings{}
+		roundingMode      sql.NullString
+		roundingIncrement sql.NullInt64
+		roundingMinimum   sql.NullInt64
+	)
+	if err := u.database.QueryRow(
+		"SELECT "+columnTimeZone+", "+columnMaxTimerDuration+", "+columnRoundingMode+", "+columnRoundingIncrement+", "+columnRoundingMinimum+
+			" FROM "+tableUsers+
+			" WHERE "+columnUserID+"=$1;",
+		[]any{userID},
+		&settings.TimeZone,
+		&settings.MaxTimerDuration,
+		&roundingMode,
+		&roundingIncrement,
+		&roundingMinimum,
+	); err != nil {
+		switch {
+		case errors.As(err, &database.NoRowsError{}):
+			return nil, fmt.Errorf("user '%s' not found", userID)
+		default:
+			return nil, u.logger.LogAndAbstractError("database error", "Error scanning user settings: %+v", err)
+		}
+	}
+
+	settings.Rounding = rounding.FromNullable(roundingMode, roundingIncrement, roundingMinimum)
+
+	return settings, nil
+}
+
+func (u *userRepositoryImpl) UpdateSettings(userID string, settings *Settings) error {
+	roundingMode, roundingIncrement, roundingMinimum := settings.Rounding.ToNullable()
+
+	res, err := u.database.Exec(
+		"UPDATE "+tableUsers+
+			" SET "+columnTimeZone+"=$2, "+columnMaxTimerDuration+"=$3, "+columnRoundingMode+"=$4, "+columnRoundingIncrement+"=$5, "+columnRoundingMinimum+"=$6"+
+			" WHERE "+columnUserID+"=$1;",
+		userID, settings.TimeZone, settings.MaxTimerDuration, roundingMode, roundingIncrement, roundingMinimum)
+	if err != nil {
+		return u.logger.LogAndAbstractError("database error", "Couldn't update user settings: %+v", err)
+	}
+
+	if rows, _ := res.RowsAffected(); rows != 1 {
+		return fmt.Errorf("user '%s' not found", userID)
+	}
+
+	return nil
 }
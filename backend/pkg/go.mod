@@ -7,9 +7,9 @@ require (
 	github.com/lib/pq v1.10.9
 	golang.org/x/crypto v0.36.0
 	github.com/coreos/go-oidc/v3 v3.14.1
+	golang.org/x/oauth2 v0.28.0
 )
 
 require (
 	github.com/go-jose/go-jose/v4 v4.0.5 // indirect
-	golang.org/x/oauth2 v0.28.0 // indirect
 )
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"time"
+
 	"github.com/caarlos0/env/v11"
 )
 
@@ -12,13 +14,39 @@ type Config struct {
 
 	FrontendAddress string `env:"FRONTEND_ADDRESS,required"`
 
-	OAuthServerURL string `env:"OAUTH_SERVER_URL,required"`
-	OAuthClientID  string `env:"OAUTH_CLIENT_ID,required"`
+	// OIDC is disabled, if no server URL is set.
+	OAuthServerURL string `env:"OAUTH_SERVER_URL"`
+	OAuthClientID  string `env:"OAUTH_CLIENT_ID"`
+	// OAuthClientSecret and OAuthRedirectURL are required for logging in via
+	// /user/oidc/login. The redirect URL points to /user/oidc/callback.
+	OAuthClientSecret string `env:"OAUTH_CLIENT_SECRET"`
+	OAuthRedirectURL  string `env:"OAUTH_REDIRECT_URL"`
+	// OAuthAdminGroups grants the admin role to members of the groups.
+	OAuthAdminGroups []string `env:"OAUTH_ADMIN_GROUPS" envSeparator:","`
 
 	LogLevel string `env:"LOG_LEVEL" envDefault:"debug"`
 	LogFile  string `env:"LOG_FILE"`
 
 	EnableCreateUser bool `env:"ENABLE_CREATE_USER" envDefault:"false"`
+	// AdminUsers are granted the admin role on startup.
+	AdminUsers []string `env:"ADMIN_USERS" envSeparator:","`
+	// TrustProxyHeaders takes the client IP from the last entry of the
+	// X-Forwarded-For header, which is added by the reverse proxy.
+	TrustProxyHeaders bool `env:"TRUST_PROXY_HEADERS" envDefault:"false"`
+
+	// Sessions expire after being idle for the timeout, but at the latest
+	// after the max lifetime.
+	SessionIdleTimeout       time.Duration `env:"SESSION_IDLE_TIMEOUT" envDefault:"24h"`
+	SessionRememberMeTimeout time.Duration `env:"SESSION_REMEMBER_ME_TIMEOUT" envDefault:"336h"`
+	SessionMaxLifetime       time.Duration `env:"SESSION_MAX_LIFETIME" envDefault:"720h"`
+
+	AutoStopInterval    time.Duration `env:"AUTO_STOP_INTERVAL" envDefault:"5m"`
+	AutoStopMaxDuration time.Duration `env:"AUTO_STOP_MAX_DURATION" envDefault:"12h"`
+	AutoStopEndOfDay    string        `env:"AUTO_STOP_END_OF_DAY"`
+
+	IdleThreshold time.Duration `env:"IDLE_THRESHOLD" envDefault:"15m"`
+
+	IdempotencyWindow time.Duration `env:"IDEMPOTENCY_WINDOW" envDefault:"24h"`
 }
 
 func ReadConfig() (Config, error) {
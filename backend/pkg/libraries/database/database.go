@@ -21,7 +21,9 @@ type Database interface {
 	Exec(query string, args ...any) (sql.Result, error)
 	ExecWithTx(tx *sql.Tx, query string, args ...any) (sql.Result, error)
 	Query(query string, args ...any) (*sql.Rows, error)
+	QueryWithTx(tx *sql.Tx, query string, args ...any) (*sql.Rows, error)
 	QueryRow(query string, args []any, dest ...any) error
+	QueryRowWithTx(tx *sql.Tx, query string, args []any, dest ...any) error
 	Close()
 }
 
@@ -96,10 +98,31 @@ func (i *impl) Query(query string, args ...any) (*sql.Rows, error) {
 	return res, err
 }
 
+func (i *impl) QueryWithTx(tx *sql.Tx, query string, args ...any) (*sql.Rows, error) {
+	i.logger.Debug("QueryWithTx '%s' with args %s", query, args)
+
+	res, err := tx.Query(query, args...)
+	if err != nil {
+		i.logger.Error("Error querying: %+v", err)
+		err = selectPqError(err)
+	}
+
+	return res, err
+}
+
 func (i *impl) QueryRow(query string, args []any, dest ...any) error {
 	i.logger.Debug("Query row '%s' with args %s", query, args)
 
-	row := i.db.QueryRow(query, args...)
+	return i.scanRow(query, i.db.QueryRow(query, args...), dest...)
+}
+
+func (i *impl) QueryRowWithTx(tx *sql.Tx, query string, args []any, dest ...any) error {
+	i.logger.Debug("QueryRowWithTx '%s' with args %s", query, args)
+
+	return i.scanRow(query, tx.QueryRow(query, args...), dest...)
+}
+
+func (i *impl) scanRow(query string, row *sql.Row, dest ...any) error {
 	if err := row.Scan(dest...); err != nil {
 		i.logger.Error("Error scanning row: %+v", err)
 		switch {
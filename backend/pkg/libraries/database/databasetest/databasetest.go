@@ -0,0 +1,76 @@
+// Package databasetest provides a database for tests, which need PostgreSQL.
+package databasetest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/DominikKuenkele/TimeTrack/libraries/database"
+	"github.com/DominikKuenkele/TimeTrack/libraries/logger"
+)
+
+// NewDatabase connects to the database configured by the TEST_POSTGRES_*
+// variables and creates the schema. The test is skipped without a database.
+func NewDatabase(t *testing.T) (database.Database, logger.Logger) {
+	t.Helper()
+
+	config := database.Config{
+		PostgresHost:     os.Getenv("TEST_POSTGRES_HOST"),
+		PostgresDB:       os.Getenv("TEST_POSTGRES_DB"),
+		PostgresUser:     os.Getenv("TEST_POSTGRES_USER"),
+		PostgresPassword: os.Getenv("TEST_POSTGRES_PASSWORD"),
+	}
+	if config.PostgresHost == "" {
+		t.Skip("TEST_POSTGRES_HOST is not set")
+	}
+
+	log, err := logger.NewLogger("error", "")
+	if err != nil {
+		t.Fatalf("error creating logger: %+v", err)
+	}
+
+	db, err := database.NewDatabase(log, config)
+	if err != nil {
+		t.Fatalf("error connecting to database: %+v", err)
+	}
+	t.Cleanup(db.Close)
+
+	schema, err := os.ReadFile(schemaPath())
+	if err != nil {
+		t.Fatalf("error reading schema: %+v", err)
+	}
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatalf("error creating schema: %+v", err)
+	}
+
+	return db, log
+}
+
+// CreateUser creates a user, which is deleted with all its data after the
+// test.
+func CreateUser(t *testing.T, db database.Database) string {
+	t.Helper()
+
+	userID := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	if _, err := db.Exec("INSERT INTO users (user_id) VALUES ($1);", userID); err != nil {
+		t.Fatalf("error creating user: %+v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := db.Exec("DELETE FROM users WHERE user_id=$1;", userID); err != nil {
+			t.Errorf("error deleting user: %+v", err)
+		}
+	})
+
+	return userID
+}
+
+// schemaPath returns the path of the init script, independent of the package,
+// which is tested.
+func schemaPath() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..", "..", "..", "database", "init_database.sql")
+}
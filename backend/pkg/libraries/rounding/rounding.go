@@ -0,0 +1,83 @@
+package rounding
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+type Mode string
+
+const (
+	Up      Mode = "up"
+	Down    Mode = "down"
+	Nearest Mode = "nearest"
+)
+
+// Rule rounds durations for billing. Activities keep their exact timestamps,
+// the rule is only applied when reporting durations.
+type Rule struct {
+	Mode               Mode   `json:"mode"`
+	IncrementInSeconds uint64 `json:"incrementInSeconds"`
+	// MinimumInSeconds is billed for every activity, even if it was shorter.
+	MinimumInSeconds uint64 `json:"minimumInSeconds"`
+}
+
+func (r *Rule) Validate() error {
+	switch r.Mode {
+	case Up, Down, Nearest:
+	default:
+		return fmt.Errorf("unknown rounding mode '%s'. Must be one of '%s', '%s' or '%s'", r.Mode, Up, Down, Nearest)
+	}
+
+	if r.IncrementInSeconds == 0 {
+		return fmt.Errorf("rounding increment must be positive")
+	}
+
+	return nil
+}
+
+// Round rounds the duration in seconds to the increment of the rule and raises
+// it to the minimum duration.
+func (r *Rule) Round(seconds uint64) uint64 {
+	rounded := seconds
+	if r.IncrementInSeconds > 0 {
+		remainder := seconds % r.IncrementInSeconds
+		rounded = seconds - remainder
+
+		switch {
+		case remainder == 0:
+		case r.Mode == Up:
+			rounded += r.IncrementInSeconds
+		case r.Mode == Nearest && remainder*2 >= r.IncrementInSeconds:
+			rounded += r.IncrementInSeconds
+		}
+	}
+
+	return max(rounded, r.MinimumInSeconds)
+}
+
+// FromNullable creates a rule from its nullable database columns. If the mode
+// is null, no rule is set and nil is returned.
+func FromNullable(mode sql.NullString, increment, minimum sql.NullInt64) *Rule {
+	if !mode.Valid {
+		return nil
+	}
+
+	return &Rule{
+		Mode:               Mode(mode.String),
+		IncrementInSeconds: uint64(increment.Int64),
+		MinimumInSeconds:   uint64(minimum.Int64),
+	}
+}
+
+// ToNullable returns the nullable database columns of the rule. A nil rule
+// results in null columns.
+func (r *Rule) ToNullable() (sql.NullString, sql.NullInt64, sql.NullInt64) {
+	if r == nil {
+		return sql.NullString{}, sql.NullInt64{}, sql.NullInt64{}
+	}
+
+	return sql.NullString{String: string(r.Mode), Valid: true},
+		sql.NullInt64{Int64: int64(r.IncrementInSeconds), Valid: true},
+		sql.NullInt64{Int64: int64(r.MinimumInSeconds), Valid: true}
+}
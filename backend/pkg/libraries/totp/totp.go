@@ -0,0 +1,130 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The parameters follow the defaults of RFC 6238, which are supported by all
+// common authenticator apps.
+const (
+	period     = 30 * time.Second
+	digits     = 6
+	secretSize = 20
+	// skew is the number of steps a code may lag behind or run ahead, to
+	// tolerate clock drift and slow typing.
+	skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Clock returns the current time. Tests can pass a fixed clock.
+type Clock func() time.Time
+
+type TOTP struct {
+	clock Clock
+}
+
+func New(clock Clock) *TOTP {
+	return &TOTP{clock: clock}
+}
+
+// GenerateSecret returns a random base32 encoded secret.
+func GenerateSecret() (string, error) {
+	b := make([]byte, secretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return encoding.EncodeToString(b), nil
+}
+
+// ProvisioningURI returns the otpauth URI of the secret, which authenticator
+// apps read from a QR code.
+func ProvisioningURI(issuer, account, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(digits))
+	query.Set("period", fmt.Sprint(int(period.Seconds())))
+
+	return (&url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}).String()
+}
+
+// Code returns the code of the secret at the given time.
+func Code(secret string, at time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return code(key, step(at)), nil
+}
+
+// Validate checks the code against the current time and returns the time step
+// it belongs to. Callers should reject steps, which were already used, to
+// prevent replaying a code.
+func (t *TOTP) Validate(secret, userCode string) (int64, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, err
+	}
+
+	current := step(t.clock())
+	for offset := int64(-skew); offset <= skew; offset++ {
+		expected := code(key, current+offset)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(userCode)) == 1 {
+			return current + offset, nil
+		}
+	}
+
+	return 0, errors.New("invalid code")
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return nil, errors.New("invalid secret")
+	}
+
+	return key, nil
+}
+
+func step(at time.Time) int64 {
+	return at.Unix() / int64(period.Seconds())
+}
+
+// code computes the HOTP value of RFC 4226 for the counter.
+func code(key []byte, counter int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for range digits {
+		modulo *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, value%modulo)
+}
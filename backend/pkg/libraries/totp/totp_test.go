@@ -0,0 +1,80 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 secret "12345678901234567890" of the test vectors in
+// RFC 6238.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// the RFC lists 8 digit codes, of which the last 6 are used
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, test := range tests {
+		code, err := Code(rfcSecret, time.Unix(test.unix, 0))
+		if err != nil {
+			t.Fatalf("error computing code at %d: %+v", test.unix, err)
+		}
+		if code != test.code {
+			t.Errorf("expected code %s at %d, got %s", test.code, test.unix, code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	totp := New(func() time.Time { return now })
+
+	tests := []struct {
+		name   string
+		offset time.Duration
+		valid  bool
+	}{
+		{"current step", 0, true},
+		{"previous step", -period, true},
+		{"next step", period, true},
+		{"two steps behind", -2 * period, false},
+		{"two steps ahead", 2 * period, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			at := now.Add(test.offset)
+			code, err := Code(rfcSecret, at)
+			if err != nil {
+				t.Fatalf("error computing code: %+v", err)
+			}
+
+			validStep, err := totp.Validate(rfcSecret, code)
+			switch {
+			case test.valid && err != nil:
+				t.Errorf("expected code to be valid, got %+v", err)
+			case test.valid && validStep != step(at):
+				t.Errorf("expected step %d, got %d", step(at), validStep)
+			case !test.valid && err == nil:
+				t.Errorf("expected code to be rejected")
+			}
+		})
+	}
+}
+
+func TestValidateInvalidSecret(t *testing.T) {
+	totp := New(time.Now)
+
+	if _, err := totp.Validate("not base32!", "123456"); err == nil {
+		t.Errorf("expected invalid secret to be rejected")
+	}
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"os"
+	_ "time/tzdata"
 
 	"github.com/DominikKuenkele/TimeTrack/activities"
 	"github.com/DominikKuenkele/TimeTrack/authentification"
@@ -9,6 +12,7 @@ import (
 	"github.com/DominikKuenkele/TimeTrack/libraries/database"
 	"github.com/DominikKuenkele/TimeTrack/libraries/logger"
 	"github.com/DominikKuenkele/TimeTrack/libraries/server"
+	"github.com/DominikKuenkele/TimeTrack/maintenance"
 	"github.com/DominikKuenkele/TimeTrack/projects"
 )
 
@@ -19,6 +23,21 @@ func defaultHandler(l logger.Logger) http.HandlerFunc {
 	}
 }
 
+func runMaintenance(l logger.Logger, db database.Database, args []string) {
+	command, err := maintenance.BuildMaintenance(l, db)
+	if err != nil {
+		l.Error(err.Error())
+		db.Close()
+		os.Exit(1)
+	}
+
+	if err := command.Run(args); err != nil {
+		l.Error(err.Error())
+		db.Close()
+		os.Exit(1)
+	}
+}
+
 func main() {
 	cfg, err := config.ReadConfig()
 	if err != nil {
@@ -42,29 +61,58 @@ func main() {
 	}
 	defer database.Close()
 
+	if len(os.Args) > 1 {
+		runMaintenance(logger, database, os.Args[1:])
+		return
+	}
+
 	server := server.NewServer("", "80", cfg.FrontendAddress, logger)
 	server.AddHandler("/", defaultHandler(logger))
 
-	authenticatorAPI, err := authentification.BuildAuthenticator(
-		logger,
-		database,
-		cfg.EnableCreateUser,
-		cfg.OAuthServerURL,
-		cfg.OAuthClientID,
-	)
+	authenticatorAPI, err := authentification.BuildAuthenticator(logger, database, authentification.Config{
+		EnableCreateUser:  cfg.EnableCreateUser,
+		OAuthServerURL:    cfg.OAuthServerURL,
+		OAuthClientID:     cfg.OAuthClientID,
+		OAuthClientSecret: cfg.OAuthClientSecret,
+		OAuthRedirectURL:  cfg.OAuthRedirectURL,
+		OAuthAdminGroups:  cfg.OAuthAdminGroups,
+		FrontendAddress:   cfg.FrontendAddress,
+		AdminUsers:        cfg.AdminUsers,
+		TrustProxyHeaders: cfg.TrustProxyHeaders,
+		Sessions: authentification.SessionConfig{
+			IdleTimeout:       cfg.SessionIdleTimeout,
+			RememberMeTimeout: cfg.SessionRememberMeTimeout,
+			MaxLifetime:       cfg.SessionMaxLifetime,
+		},
+	})
 	if err != nil {
 		logger.Error(err.Error())
 		return
 	}
 	server.AddHandler(authentification.Prefix+"/", authenticatorAPI.HTTPHandler)
+	server.AddHandler(authentification.AdminPrefix+"/", authenticatorAPI.Authenticate(
+		authenticatorAPI.RequireRole(authentification.RoleAdmin, authenticatorAPI.AdminHTTPHandler),
+	))
 
-	projectAPI, err := projects.BuildProject(logger, database)
+	projectAPI, err := projects.BuildProject(logger, database, cfg.IdleThreshold, cfg.IdempotencyWindow)
 	if err != nil {
 		logger.Error(err.Error())
 		return
 	}
 	server.AddHandler(projects.Prefix+"/", authenticatorAPI.Authenticate(projectAPI.HTTPHandler))
 
+	autoStopper, err := projects.BuildAutoStopper(logger, database, projects.AutoStopConfig{
+		Interval:          cfg.AutoStopInterval,
+		MaxDuration:       cfg.AutoStopMaxDuration,
+		EndOfDay:          cfg.AutoStopEndOfDay,
+		IdempotencyWindow: cfg.IdempotencyWindow,
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+	go autoStopper.Run(context.Background())
+
 	activityAPI, err := activities.BuildActivity(logger, database)
 	if err != nil {
 		logger.Error(err.Error())
@@ -0,0 +1,144 @@
+package maintenance
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/DominikKuenkele/TimeTrack/libraries/logger"
+	"github.com/DominikKuenkele/TimeTrack/projects"
+)
+
+type Command interface {
+	Run(args []string) error
+}
+
+type commandImpl struct {
+	logger             logger.Logger
+	maintenanceHandler Handler
+	out                io.Writer
+}
+
+var _ Command = &commandImpl{}
+
+func NewCommand(logger logger.Logger, maintenanceHandler Handler, out io.Writer) Command {
+	return &commandImpl{
+		logger:             logger,
+		maintenanceHandler: maintenanceHandler,
+		out:                out,
+	}
+}
+
+type subcommandFunc func(args []string) error
+
+func (c *commandImpl) Run(args []string) error {
+	subcommandMap := map[string]subcommandFunc{
+		"check-overtime":   c.runCheckOvertime,
+		"rebuild-worktime": c.runRebuildWorktime,
+	}
+
+	if len(args) == 0 {
+		return errors.New("no command given")
+	}
+
+	subcommand, found := subcommandMap[args[0]]
+	if !found {
+		return fmt.Errorf("command '%s' not supported", args[0])
+	}
+
+	return subcommand(args[1:])
+}
+
+func (c *commandImpl) runCheckOvertime(args []string) error {
+	flags := flag.NewFlagSet("check-overtime", flag.ContinueOnError)
+	flags.SetOutput(c.out)
+	userID := flags.String("user", "", "only check the given user instead of all users")
+	fix := flags.Bool("fix", false, "overwrite drifted balances with the recomputed value")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	drifts, err := c.maintenanceHandler.CheckOvertime(*userID, *fix)
+	if err != nil {
+		return err
+	}
+
+	for _, drift := range drifts {
+		fmt.Fprintf(c.out, "user '%s': stored %d, expected %d, drift %d\n",
+			drift.UserID, drift.Stored, drift.Expected, drift.Stored-drift.Expected)
+	}
+
+	switch {
+	case len(drifts) == 0:
+		fmt.Fprintln(c.out, "overtime is consistent")
+	case *fix:
+		fmt.Fprintf(c.out, "fixed overtime of %d user(s)\n", len(drifts))
+	default:
+		return fmt.Errorf("overtime of %d user(s) drifted", len(drifts))
+	}
+
+	return nil
+}
+
+func (c *commandImpl) runRebuildWorktime(args []string) error {
+	flags := flag.NewFlagSet("rebuild-worktime", flag.ContinueOnError)
+	flags.SetOutput(c.out)
+	userID := flags.String("user", "", "only rebuild the given user instead of all users")
+	fromParam := flags.String("from", "", "first day to rebuild, formatted as "+time.DateOnly)
+	toParam := flags.String("to", time.Now().Format(time.DateOnly), "last day to rebuild, formatted as "+time.DateOnly)
+	dryRun := flags.Bool("dry-run", false, "only report differences without changing the worktime")
+	batchSize := flags.Int("batch-size", 31, "number of days rebuilt within one transaction")
+	pause := flags.Duration("pause", 100*time.Millisecond, "pause between two batches")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *fromParam == "" {
+		return errors.New("from must be set")
+	}
+
+	from, err := time.Parse(time.DateOnly, *fromParam)
+	if err != nil {
+		return fmt.Errorf("invalid from parameter: %s. Must be of format '%s'", *fromParam, time.DateOnly)
+	}
+
+	to, err := time.Parse(time.DateOnly, *toParam)
+	if err != nil {
+		return fmt.Errorf("invalid to parameter: %s. Must be of format '%s'", *toParam, time.DateOnly)
+	}
+
+	diffs, err := c.maintenanceHandler.RebuildWorktime(*userID, from, to, RebuildOptions{
+		DryRun:    *dryRun,
+		BatchSize: *batchSize,
+		Pause:     *pause,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, diff := range diffs {
+		fmt.Fprintf(c.out, "user '%s' on %s: stored %s, expected %s\n",
+			diff.UserID, diff.Day.Format(time.DateOnly), formatWorktime(diff.Stored), formatWorktime(diff.Expected))
+	}
+
+	switch {
+	case len(diffs) == 0:
+		fmt.Fprintln(c.out, "worktime is consistent")
+	case *dryRun:
+		fmt.Fprintf(c.out, "worktime of %d day(s) differs\n", len(diffs))
+	default:
+		fmt.Fprintf(c.out, "rebuilt worktime of %d day(s)\n", len(diffs))
+	}
+
+	return nil
+}
+
+func formatWorktime(worktime *projects.Worktime) string {
+	if worktime == nil {
+		return "none"
+	}
+
+	return fmt.Sprintf("worktime %ds/breaktime %ds", worktime.Worktime, worktime.Breaktime)
+}
@@ -0,0 +1,22 @@
+package maintenance
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/DominikKuenkele/TimeTrack/libraries/database"
+	"github.com/DominikKuenkele/TimeTrack/libraries/logger"
+	"github.com/DominikKuenkele/TimeTrack/projects"
+)
+
+func BuildMaintenance(logger logger.Logger, database database.Database) (Command, error) {
+	projectRepository, err := projects.NewRepository(logger, database)
+	if err != nil {
+		return nil, fmt.Errorf("error building maintenance. %+v", err)
+	}
+
+	maintenanceHandler := NewHandler(logger, projectRepository)
+	command := NewCommand(logger, maintenanceHandler, os.Stdout)
+
+	return command, nil
+}
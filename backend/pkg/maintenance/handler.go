@@ -0,0 +1,132 @@
+package maintenance
+
+import (
+	"errors"
+	"time"
+
+	"github.com/DominikKuenkele/TimeTrack/libraries/logger"
+	"github.com/DominikKuenkele/TimeTrack/projects"
+)
+
+type Handler interface {
+	CheckOvertime(userID string, fix bool) ([]*projects.OvertimeDrift, error)
+	RebuildWorktime(userID string, from, to time.Time, options RebuildOptions) ([]*projects.WorktimeDiff, error)
+}
+
+type RebuildOptions struct {
+	DryRun    bool
+	BatchSize int
+	Pause     time.Duration
+}
+
+type handlerImpl struct {
+	logger     logger.Logger
+	repository projects.Repository
+}
+
+var _ Handler = &handlerImpl{}
+
+func NewHandler(l logger.Logger, repository projects.Repository) Handler {
+	return &handlerImpl{
+		logger:     l,
+		repository: repository,
+	}
+}
+
+// CheckOvertime recomputes the overtime balance of a user (or all users, if
+// userID is empty) from the worktime table and reports every balance that
+// drifted from it. If fix is set, drifted balances are overwritten.
+func (h *handlerImpl) CheckOvertime(userID string, fix bool) ([]*projects.OvertimeDrift, error) {
+	userIDs, err := h.userIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var drifts []*projects.OvertimeDrift
+	for _, userID := range userIDs {
+		worktimes, err := h.repository.GetWorktime(userID)
+		if err != nil {
+			return nil, err
+		}
+
+		var expected int64
+		for _, worktime := range worktimes {
+			expected += worktime.Overtime()
+		}
+
+		stored, err := h.repository.GetOvertime(userID)
+		if err != nil {
+			return nil, err
+		}
+
+		if stored == expected {
+			continue
+		}
+
+		drifts = append(drifts, &projects.OvertimeDrift{
+			UserID:   userID,
+			Stored:   stored,
+			Expected: expected,
+		})
+
+		if fix {
+			h.logger.Info("Fixing overtime of user '%s' from %d to %d", userID, stored, expected)
+			if err := h.repository.SetOvertime(userID, expected); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return drifts, nil
+}
+
+// RebuildWorktime recalculates the worktime of a user (or all users, if userID
+// is empty) for every day from from to to from the activities and reports every
+// day, whose worktime differed. The days are processed in batches, each in its
+// own short transaction, so that the API isn't blocked while rebuilding.
+func (h *handlerImpl) RebuildWorktime(userID string, from, to time.Time, options RebuildOptions) ([]*projects.WorktimeDiff, error) {
+	if to.Before(from) {
+		return nil, errors.New("from must not be after to")
+	}
+
+	if options.BatchSize < 1 {
+		return nil, errors.New("batch size must be positive")
+	}
+
+	userIDs, err := h.userIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var days []time.Time
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		days = append(days, day)
+	}
+
+	var diffs []*projects.WorktimeDiff
+	for _, userID := range userIDs {
+		h.logger.Info("Rebuilding worktime of user '%s'", userID)
+
+		for start := 0; start < len(days); start += options.BatchSize {
+			end := min(start+options.BatchSize, len(days))
+
+			batchDiffs, err := h.repository.RebuildWorktime(userID, days[start:end], options.DryRun)
+			if err != nil {
+				return nil, err
+			}
+			diffs = append(diffs, batchDiffs...)
+
+			time.Sleep(options.Pause)
+		}
+	}
+
+	return diffs, nil
+}
+
+func (h *handlerImpl) userIDs(userID string) ([]string, error) {
+	if userID != "" {
+		return []string{userID}, nil
+	}
+
+	return h.repository.GetTrackingUserIDs()
+}
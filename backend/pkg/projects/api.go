@@ -1,17 +1,26 @@
 package projects
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/DominikKuenkele/TimeTrack/libraries/logger"
+	"github.com/DominikKuenkele/TimeTrack/libraries/rounding"
 )
 
 const Prefix = "/projects"
+const IdempotencyKeyHeader = "Idempotency-Key"
+const IdempotentReplayedHeader = "Idempotent-Replayed"
 const DefaultPage = 1
 const DefaultPerPage = 2
 
@@ -37,9 +46,14 @@ type actionFunc func(w http.ResponseWriter, r *http.Request, name string) error
 
 func (a *apiImpl) HTTPHandler(w http.ResponseWriter, r *http.Request) {
 	actionMap := map[string]actionFunc{
-		"":      a.handleNoAction,
-		"start": a.handleStartProjectAction,
-		"stop":  a.handleStopProjectAction,
+		"":          a.handleNoAction,
+		"start":     a.idempotent(a.handleStartProjectAction),
+		"stop":      a.idempotent(a.handleStopProjectAction),
+		"pause":     a.handlePauseProjectAction,
+		"resume":    a.handleResumeProjectAction,
+		"heartbeat": a.handleHeartbeatAction,
+		"focus":     a.handleFocusAction,
+		"rounding":  a.handleRoundingAction,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -74,8 +88,133 @@ func (a *apiImpl) HTTPHandler(w http.ResponseWriter, r *http.Request) {
 
 	err = actionFunction(w, r, name)
 	if err != nil {
-		a.sendInvalidInputResponse(w, err)
+		var overlapError *OverlapError
+		switch {
+		case errors.Is(err, ErrAlreadyStarted), errors.Is(err, ErrNotRunning):
+			a.sendConflictResponse(w, err)
+		case errors.As(err, &overlapError):
+			a.sendOverlapResponse(w, overlapError)
+		default:
+			a.sendInvalidInputResponse(w, err)
+		}
+	}
+}
+
+// sendConflictResponse signals, that the project already is in the requested
+// state, which clients may treat as success.
+func (a *apiImpl) sendConflictResponse(w http.ResponseWriter, err error) {
+	a.logger.Info(err.Error())
+
+	w.WriteHeader(http.StatusConflict)
+
+	jsonResponse, _ := json.Marshal(
+		map[string]string{
+			"error":   "Conflict",
+			"message": err.Error(),
+		})
+	w.Write(jsonResponse)
+}
+
+// sendOverlapResponse lists the activities, which a backdated start would
+// overlap.
+func (a *apiImpl) sendOverlapResponse(w http.ResponseWriter, err *OverlapError) {
+	a.logger.Info(err.Error())
+
+	w.WriteHeader(http.StatusConflict)
+
+	jsonResponse, _ := json.Marshal(
+		map[string]any{
+			"error":      "Conflict",
+			"message":    err.Error(),
+			"activities": err.Activities,
+		})
+	w.Write(jsonResponse)
+}
+
+// idempotent replays the response of an earlier successful request with the
+// same Idempotency-Key header instead of running the action again. Concurrent
+// requests with the same key wait for the first one.
+func (a *apiImpl) idempotent(action actionFunc) actionFunc {
+	return func(w http.ResponseWriter, r *http.Request, name string) error {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			return action(w, r, name)
+		}
+
+		request, err := fingerprint(r)
+		if err != nil {
+			return err
+		}
+
+		response, err := a.projectHandler.ReserveIdempotencyKey(r.Context(), key, request)
+		if err != nil {
+			return err
+		}
+
+		if response != nil {
+			w.Header().Set(IdempotentReplayedHeader, "true")
+			w.WriteHeader(response.StatusCode)
+			w.Write(response.Body)
+			return nil
+		}
+
+		recorder := &responseRecorder{
+			ResponseWriter: w,
+			statusCode:     http.StatusOK,
+		}
+		if err := action(recorder, r, name); err != nil {
+			if err := a.projectHandler.ReleaseIdempotencyKey(r.Context(), key); err != nil {
+				a.logger.Error("Couldn't release idempotency key '%s': %+v", key, err)
+			}
+			return err
+		}
+
+		// the response was already sent, so a failure only prevents replaying
+		if err := a.projectHandler.SaveIdempotentResponse(r.Context(), key, &IdempotentResponse{
+			Request:    request,
+			StatusCode: recorder.statusCode,
+			Body:       recorder.body.Bytes(),
+		}); err != nil {
+			a.logger.Error("Couldn't save response for idempotency key '%s': %+v", key, err)
+		}
+
+		return nil
+	}
+}
+
+// fingerprint identifies the request by its method, path and a hash of its
+// body, so that a key reused with a different body is detected. The body is
+// restored for the action.
+func fingerprint(r *http.Request) (string, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			return "", fmt.Errorf("couldn't read request body: %w", err)
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
 	}
+
+	hash := sha256.Sum256(body)
+	return r.Method + " " + r.URL.Path + " " + hex.EncodeToString(hash[:]), nil
+}
+
+// responseRecorder passes the response through, but keeps a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	r.statusCode = statusCode
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
 }
 
 func (a *apiImpl) sendInvalidInputResponse(w http.ResponseWriter, err error) {
@@ -156,7 +295,28 @@ func (a *apiImpl) handleNoAction(w http.ResponseWriter, r *http.Request, name st
 func (a *apiImpl) handleStartProjectAction(w http.ResponseWriter, r *http.Request, name string) error {
 	switch r.Method {
 	case http.MethodPost:
-		if err := a.projectHandler.StartProject(r.Context(), name); err != nil {
+		type startProject struct {
+			StartedAt *time.Time `json:"startedAt"`
+			Focus     *struct {
+				PlannedDurationInSeconds uint64 `json:"plannedDurationInSeconds"`
+				AutoStop                 bool   `json:"autoStop"`
+			} `json:"focus"`
+		}
+
+		var startData startProject
+		if err := decodeOptionalBody(r, &startData); err != nil {
+			return err
+		}
+
+		var focus *Focus
+		if startData.Focus != nil {
+			focus = &Focus{
+				PlannedDuration: time.Duration(startData.Focus.PlannedDurationInSeconds) * time.Second,
+				AutoStop:        startData.Focus.AutoStop,
+			}
+		}
+
+		if err := a.projectHandler.StartProject(r.Context(), name, startData.StartedAt, focus); err != nil {
 			return err
 		}
 
@@ -171,7 +331,149 @@ func (a *apiImpl) handleStartProjectAction(w http.ResponseWriter, r *http.Reques
 func (a *apiImpl) handleStopProjectAction(w http.ResponseWriter, r *http.Request, name string) error {
 	switch r.Method {
 	case http.MethodPost:
-		if err := a.projectHandler.StopProject(r.Context(), name); err != nil {
+		type stopProject struct {
+			EndedAt     *time.Time `json:"endedAt"`
+			DiscardIdle bool       `json:"discardIdle"`
+		}
+
+		var stopData stopProject
+		if err := decodeOptionalBody(r, &stopData); err != nil {
+			return err
+		}
+
+		stopResult, err := a.projectHandler.StopProject(r.Context(), name, stopData.EndedAt, stopData.DiscardIdle)
+		if err != nil {
+			return err
+		}
+
+		w.WriteHeader(http.StatusOK)
+		jsonResponse, _ := json.Marshal(stopResult)
+		w.Write(jsonResponse)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+
+	return nil
+}
+
+func (a *apiImpl) handlePauseProjectAction(w http.ResponseWriter, r *http.Request, name string) error {
+	switch r.Method {
+	case http.MethodPost:
+		type pauseProject struct {
+			PausedAt *time.Time `json:"pausedAt"`
+		}
+
+		var pauseData pauseProject
+		if err := decodeOptionalBody(r, &pauseData); err != nil {
+			return err
+		}
+
+		activity, err := a.projectHandler.PauseProject(r.Context(), name, pauseData.PausedAt)
+		if err != nil {
+			return err
+		}
+
+		w.WriteHeader(http.StatusOK)
+		jsonResponse, _ := json.Marshal(activity)
+		w.Write(jsonResponse)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+
+	return nil
+}
+
+func (a *apiImpl) handleResumeProjectAction(w http.ResponseWriter, r *http.Request, name string) error {
+	switch r.Method {
+	case http.MethodPost:
+		type resumeProject struct {
+			ResumedAt *time.Time `json:"resumedAt"`
+		}
+
+		var resumeData resumeProject
+		if err := decodeOptionalBody(r, &resumeData); err != nil {
+			return err
+		}
+
+		if err := a.projectHandler.ResumeProject(r.Context(), name, resumeData.ResumedAt); err != nil {
+			return err
+		}
+
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+
+	return nil
+}
+
+func (a *apiImpl) handleHeartbeatAction(w http.ResponseWriter, r *http.Request, name string) error {
+	switch r.Method {
+	case http.MethodPost:
+		if err := a.projectHandler.Heartbeat(r.Context(), name); err != nil {
+			return err
+		}
+
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+
+	return nil
+}
+
+// decodeOptionalBody decodes the JSON body of the request into v, if there is
+// a body at all.
+func decodeOptionalBody(r *http.Request, v any) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return errors.New("error parsing parameters")
+	}
+
+	return nil
+}
+
+func (a *apiImpl) handleFocusAction(w http.ResponseWriter, r *http.Request, name string) error {
+	switch r.Method {
+	case http.MethodGet:
+		statistics, err := a.projectHandler.GetFocusStatistics(r.Context(), name)
+		if err != nil {
+			return err
+		}
+
+		w.WriteHeader(http.StatusOK)
+		jsonResponse, _ := json.Marshal(statistics)
+		w.Write(jsonResponse)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+
+	return nil
+}
+
+func (a *apiImpl) handleRoundingAction(w http.ResponseWriter, r *http.Request, name string) error {
+	switch r.Method {
+	case http.MethodGet:
+		rule, err := a.projectHandler.GetRounding(r.Context(), name)
+		if err != nil {
+			return err
+		}
+
+		w.WriteHeader(http.StatusOK)
+		jsonResponse, _ := json.Marshal(rule)
+		w.Write(jsonResponse)
+	case http.MethodPut:
+		var rule rounding.Rule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			return errors.New("error parsing parameters")
+		}
+
+		if err := a.projectHandler.SetRounding(r.Context(), name, &rule); err != nil {
+			return err
+		}
+
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		if err := a.projectHandler.SetRounding(r.Context(), name, nil); err != nil {
 			return err
 		}
 
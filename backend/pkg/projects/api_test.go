@@ -0,0 +1,36 @@
+package projects
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	request := func(body string) string {
+		r := httptest.NewRequest("POST", "/projects/project/start", strings.NewReader(body))
+
+		fingerprint, err := fingerprint(r)
+		if err != nil {
+			t.Fatalf("error fingerprinting request: %+v", err)
+		}
+
+		restored, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("error reading restored body: %+v", err)
+		}
+		if string(restored) != body {
+			t.Errorf("expected body '%s' to be restored, got '%s'", body, restored)
+		}
+
+		return fingerprint
+	}
+
+	if request(`{"startedAt":"2024-03-05T09:00:00Z"}`) != request(`{"startedAt":"2024-03-05T09:00:00Z"}`) {
+		t.Errorf("expected equal requests to have the same fingerprint")
+	}
+	if request(`{"startedAt":"2024-03-05T09:00:00Z"}`) == request(`{"startedAt":"2024-03-05T10:00:00Z"}`) {
+		t.Errorf("expected requests with different bodies to have different fingerprints")
+	}
+}
@@ -0,0 +1,175 @@
+package projects
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/DominikKuenkele/TimeTrack/libraries/logger"
+)
+
+// AutoStopper periodically stops timers, which were forgotten to be stopped,
+// and removes expired idempotency keys.
+type AutoStopper interface {
+	Run(ctx context.Context)
+}
+
+type AutoStopConfig struct {
+	// Interval between two checks for forgotten timers.
+	Interval time.Duration
+	// MaxDuration of a timer, if the user didn't set their own.
+	MaxDuration time.Duration
+	// EndOfDay is the time of day formatted as "15:04" in the time zone of the
+	// user, after which running timers are stopped. Empty disables it.
+	EndOfDay string
+	// IdempotencyWindow after which idempotency keys are removed. Zero keeps
+	// them.
+	IdempotencyWindow time.Duration
+}
+
+type autoStopperImpl struct {
+	logger            logger.Logger
+	repository        Repository
+	interval          time.Duration
+	maxDuration       time.Duration
+	endOfDay          *time.Time
+	idempotencyWindow time.Duration
+}
+
+var _ AutoStopper = &autoStopperImpl{}
+
+func NewAutoStopper(l logger.Logger, repository Repository, config AutoStopConfig) (AutoStopper, error) {
+	if config.Interval <= 0 {
+		return nil, fmt.Errorf("auto stop interval must be positive")
+	}
+
+	if config.MaxDuration <= 0 {
+		return nil, fmt.Errorf("auto stop max duration must be positive")
+	}
+
+	autoStopper := &autoStopperImpl{
+		logger:            l,
+		repository:        repository,
+		interval:          config.Interval,
+		maxDuration:       config.MaxDuration,
+		idempotencyWindow: config.IdempotencyWindow,
+	}
+
+	if config.EndOfDay != "" {
+		endOfDay, err := time.Parse("15:04", config.EndOfDay)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse end of day '%s'. Must be of format '15:04'", config.EndOfDay)
+		}
+		autoStopper.endOfDay = &endOfDay
+	}
+
+	return autoStopper, nil
+}
+
+// Run checks for forgotten timers until the context is cancelled.
+func (a *autoStopperImpl) Run(ctx context.Context) {
+	a.logger.Info("Starting auto stop of forgotten timers every %s", a.interval)
+
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.stopElapsedFocusSessions()
+			a.stopForgottenTimers()
+			a.deleteExpiredIdempotencyKeys()
+		}
+	}
+}
+
+func (a *autoStopperImpl) stopForgottenTimers() {
+	runningProjects, err := a.repository.GetRunningProjects()
+	if err != nil {
+		a.logger.Error("Couldn't get running projects for auto stop: %+v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, project := range runningProjects {
+		cutoff, err := a.cutoff(project)
+		if err != nil {
+			a.logger.Error("Couldn't determine auto stop of project '%s' of user '%s': %+v", project.Name, project.UserID, err)
+			continue
+		}
+
+		if cutoff.After(now) {
+			continue
+		}
+
+		a.logger.Info("Auto stopping project '%s' of user '%s' at %s", project.Name, project.UserID, cutoff.Format(time.RFC3339))
+		if err := a.repository.AutoStopProject(project.UserID, project.Name, cutoff); err != nil {
+			a.logger.Error("Couldn't auto stop project '%s' of user '%s': %+v", project.Name, project.UserID, err)
+		}
+	}
+}
+
+func (a *autoStopperImpl) deleteExpiredIdempotencyKeys() {
+	if a.idempotencyWindow <= 0 {
+		return
+	}
+
+	deleted, err := a.repository.DeleteExpiredIdempotencyKeys(time.Now().Add(-a.idempotencyWindow))
+	if err != nil {
+		a.logger.Error("Couldn't delete expired idempotency keys: %+v", err)
+		return
+	}
+
+	if deleted > 0 {
+		a.logger.Info("Deleted %d expired idempotency keys", deleted)
+	}
+}
+
+func (a *autoStopperImpl) stopElapsedFocusSessions() {
+	sessions, err := a.repository.GetElapsedFocusSessions()
+	if err != nil {
+		a.logger.Error("Couldn't get elapsed focus sessions: %+v", err)
+		return
+	}
+
+	for _, session := range sessions {
+		a.logger.Info("Stopping focus session of project '%s' of user '%s' at %s", session.ProjectName, session.UserID, session.PlannedEndAt.Format(time.RFC3339))
+		if err := a.repository.StopFocusSession(session.UserID, session.ProjectName, session.PlannedEndAt); err != nil {
+			a.logger.Error("Couldn't stop focus session of project '%s' of user '%s': %+v", session.ProjectName, session.UserID, err)
+		}
+	}
+}
+
+// cutoff returns the time the running project is stopped at: after the maximum
+// duration of the user or at the end of the day the project was started on,
+// whichever is earlier.
+func (a *autoStopperImpl) cutoff(project *Project) (time.Time, error) {
+	maxDuration := a.maxDuration
+	userMaxDuration, err := a.repository.GetMaxTimerDuration(project.UserID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if userMaxDuration != nil {
+		maxDuration = *userMaxDuration
+	}
+
+	startedAt := *project.StartedAt
+	cutoff := startedAt.Add(maxDuration)
+
+	if a.endOfDay != nil {
+		// started at is already in the time zone of the user
+		year, month, day := startedAt.Date()
+		endOfDay := time.Date(year, month, day, a.endOfDay.Hour(), a.endOfDay.Minute(), 0, 0, startedAt.Location())
+		if !endOfDay.After(startedAt) {
+			endOfDay = endOfDay.AddDate(0, 0, 1)
+		}
+
+		if endOfDay.Before(cutoff) {
+			cutoff = endOfDay
+		}
+	}
+
+	return cutoff, nil
+}
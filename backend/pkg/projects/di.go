@@ -2,19 +2,34 @@ package projects
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/DominikKuenkele/TimeTrack/libraries/database"
 	"github.com/DominikKuenkele/TimeTrack/libraries/logger"
 )
 
-func BuildProject(logger logger.Logger, database database.Database) (API, error) {
+func BuildProject(logger logger.Logger, database database.Database, idleThreshold, idempotencyWindow time.Duration) (API, error) {
 	projectRepository, err := NewRepository(logger, database)
 	if err != nil {
 		return nil, fmt.Errorf("errror building project. %+v", err)
 	}
 
-	projectHandler := NewHandler(logger, projectRepository)
+	projectHandler := NewHandler(logger, projectRepository, idleThreshold, idempotencyWindow)
 	api := NewAPI(logger, projectHandler)
 
 	return api, nil
 }
+
+func BuildAutoStopper(logger logger.Logger, database database.Database, config AutoStopConfig) (AutoStopper, error) {
+	projectRepository, err := NewRepository(logger, database)
+	if err != nil {
+		return nil, fmt.Errorf("error building auto stopper. %+v", err)
+	}
+
+	autoStopper, err := NewAutoStopper(logger, projectRepository, config)
+	if err != nil {
+		return nil, fmt.Errorf("error building auto stopper. %+v", err)
+	}
+
+	return autoStopper, nil
+}
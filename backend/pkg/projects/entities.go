@@ -2,15 +2,34 @@ package projects
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"time"
+
+	"github.com/DominikKuenkele/TimeTrack/libraries/rounding"
+)
+
+// WorkHours is the expected worktime per day in seconds.
+const WorkHours = 8 * 60 * 60
+
+// ErrAlreadyStarted and ErrNotRunning are returned, if the project already is
+// in the requested state.
+var (
+	ErrAlreadyStarted = errors.New("project already started")
+	ErrNotRunning     = errors.New("project not running")
 )
 
 type Project struct {
-	ID               int        `json:"id"`
-	UserID           string     `json:"userID"`
-	Name             string     `json:"name"`
-	RuntimeInSeconds uint64     `json:"runtimeInSeconds"`
-	StartedAt        *time.Time `json:"startedAt"`
+	ID               int    `json:"id"`
+	UserID           string `json:"userID"`
+	Name             string `json:"name"`
+	RuntimeInSeconds uint64 `json:"runtimeInSeconds"`
+	// RoundedRuntimeInSeconds is only set, if a rounding rule applies.
+	RoundedRuntimeInSeconds *uint64    `json:"roundedRuntimeInSeconds,omitempty"`
+	StartedAt               *time.Time `json:"startedAt"`
+	PausedAt                *time.Time `json:"pausedAt"`
+	// RemainingSeconds of a running focus session. Negative, if it overran.
+	RemainingSeconds *int64     `json:"remainingSeconds,omitempty"`
 	Activities       Activities `json:"activities"`
 	CreatedAt        time.Time  `json:"createdAt"`
 	UpdatedAt        time.Time  `json:"updatedAt"`
@@ -30,24 +49,30 @@ type DbProject struct {
 	UserID    string
 	Name      string
 	StartedAt sql.NullTime
+	PausedAt  sql.NullTime
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
-func (p *DbProject) ToDomain() *Project {
+func (p *DbProject) ToDomain(location *time.Location) *Project {
 	project := &Project{
 		ID:        p.ID,
 		UserID:    p.UserID,
 		Name:      p.Name,
-		CreatedAt: p.CreatedAt.Local(),
-		UpdatedAt: p.UpdatedAt.Local(),
+		CreatedAt: p.CreatedAt.In(location),
+		UpdatedAt: p.UpdatedAt.In(location),
 	}
 
 	if p.StartedAt.Valid {
-		localStartedAt := p.StartedAt.Time.Local()
+		localStartedAt := p.StartedAt.Time.In(location)
 		project.StartedAt = &localStartedAt
 	}
 
+	if p.PausedAt.Valid {
+		localPausedAt := p.PausedAt.Time.In(location)
+		project.PausedAt = &localPausedAt
+	}
+
 	return project
 }
 
@@ -56,8 +81,19 @@ type Activity struct {
 	ProjectName string     `json:"projectName"`
 	StartedAt   time.Time  `json:"startedAt"`
 	EndedAt     *time.Time `json:"endedAt"`
-	CreatedAt   time.Time  `json:"createdAt"`
-	UpdatedAt   time.Time  `json:"updatesAt"`
+	AutoStopped bool       `json:"autoStopped"`
+	LastSeenAt  *time.Time `json:"lastSeenAt"`
+	// Paused is set, if the activity was ended by pausing the project.
+	Paused bool `json:"paused"`
+	// PlannedDurationInSeconds is only set for focus sessions.
+	PlannedDurationInSeconds *uint64    `json:"plannedDurationInSeconds,omitempty"`
+	PlannedEndAt             *time.Time `json:"plannedEndAt,omitempty"`
+	Overrun                  bool       `json:"overrun"`
+	// RoundedDurationInSeconds is only set for ended activities, if a rounding
+	// rule applies.
+	RoundedDurationInSeconds *uint64   `json:"roundedDurationInSeconds,omitempty"`
+	CreatedAt                time.Time `json:"createdAt"`
+	UpdatedAt                time.Time `json:"updatesAt"`
 }
 
 type Activities []*Activity
@@ -73,11 +109,166 @@ func (a Activities) CalculateRuntime() uint64 {
 	return runtime
 }
 
+// CalculateRoundedRuntime sums up the rounded durations of the activities. It
+// returns nil, if no rounding rule applies.
+func (a Activities) CalculateRoundedRuntime() *uint64 {
+	var (
+		runtime uint64
+		rounded bool
+	)
+	for _, activity := range a {
+		if activity.RoundedDurationInSeconds != nil {
+			runtime += *activity.RoundedDurationInSeconds
+			rounded = true
+		}
+	}
+
+	if !rounded {
+		return nil
+	}
+
+	return &runtime
+}
+
+// CalculateRemainingFocus returns the remaining seconds of the running focus
+// session or nil, if no focus session is running.
+func (a Activities) CalculateRemainingFocus() *int64 {
+	for _, activity := range a {
+		if activity.EndedAt == nil && activity.PlannedEndAt != nil {
+			remaining := int64(time.Until(*activity.PlannedEndAt).Seconds())
+			return &remaining
+		}
+	}
+
+	return nil
+}
+
+// Clip returns copies of the activities, which are cut to the portions that
+// fall between start and end. Running activities are considered to last until
+// end, if end already passed.
+func (a Activities) Clip(start, end time.Time) Activities {
+	clipped := make(Activities, 0, len(a))
+	for _, activity := range a {
+		portion := *activity
+
+		if portion.StartedAt.Before(start) {
+			portion.StartedAt = start
+		}
+
+		switch {
+		case portion.EndedAt == nil && !end.After(time.Now()):
+			portion.EndedAt = &end
+		case portion.EndedAt != nil && portion.EndedAt.After(end):
+			portion.EndedAt = &end
+		}
+
+		clipped = append(clipped, &portion)
+	}
+
+	return clipped
+}
+
+// Focus describes a focus session, which is planned to last for a fixed
+// duration.
+type Focus struct {
+	PlannedDuration time.Duration
+	// AutoStop stops the session at its planned end. Otherwise it is only
+	// marked as overrun.
+	AutoStop bool
+}
+
+type ElapsedFocusSession struct {
+	UserID       string
+	ProjectName  string
+	PlannedEndAt time.Time
+}
+
+// FocusStatistics summarizes the finished focus sessions of a project.
+type FocusStatistics struct {
+	Sessions       int    `json:"sessions"`
+	Overruns       int    `json:"overruns"`
+	PlannedSeconds uint64 `json:"plannedSeconds"`
+	ActualSeconds  uint64 `json:"actualSeconds"`
+}
+
+func (a Activities) CalculateFocusStatistics() *FocusStatistics {
+	statistics := &FocusStatistics{}
+	for _, activity := range a {
+		if activity.PlannedDurationInSeconds == nil || activity.EndedAt == nil {
+			continue
+		}
+
+		statistics.Sessions++
+		statistics.PlannedSeconds += *activity.PlannedDurationInSeconds
+		statistics.ActualSeconds += uint64(activity.EndedAt.Sub(activity.StartedAt).Seconds())
+		if activity.Overrun {
+			statistics.Overruns++
+		}
+	}
+
+	return statistics
+}
+
+// OverlapError is returned, if an activity would overlap other activities,
+// which would count the time twice.
+type OverlapError struct {
+	Activities Activities
+}
+
+func (e *OverlapError) Error() string {
+	return fmt.Sprintf("activity overlaps with %d other activities", len(e.Activities))
+}
+
+// IdempotentResponse is the response to a request with an idempotency key,
+// which is replayed, if the request is retried.
+type IdempotentResponse struct {
+	// Request identifies the request by method and path.
+	Request string
+	// Pending is set, while the first request with the key is still running.
+	Pending    bool
+	StatusCode int
+	Body       []byte
+}
+
+// StopResult is the outcome of stopping a project. If the user was idle for
+// long before stopping, the idle time is offered to be discarded.
+type StopResult struct {
+	Activity      *Activity  `json:"activity"`
+	IdleSince     *time.Time `json:"idleSince,omitempty"`
+	IdleSeconds   uint64     `json:"idleSeconds,omitempty"`
+	IdleDiscarded bool       `json:"idleDiscarded"`
+}
+
 type DailyActivities struct {
 	Activities Activities `json:"activities"`
 	Breaktime  uint64     `json:"breaktime"`
 	Worktime   uint64     `json:"worktime"`
 	Overtime   int64      `json:"overtime"`
+	// RoundedRuntime sums up the rounded durations of the activities started on
+	// the day. It is only set, if a rounding rule applies.
+	RoundedRuntime *uint64 `json:"roundedRuntime,omitempty"`
+}
+
+// CalculateForDay calculates worktime and breaktime of the day between dayStart
+// and dayEnd, only taking the portions of the activities into account, which
+// fall into the day.
+func (d *DailyActivities) CalculateForDay(dayStart, dayEnd time.Time) {
+	portions := DailyActivities{
+		Activities: d.Activities.Clip(dayStart, dayEnd),
+	}
+	portions.CalculateWorktime()
+	portions.CalculateBreaktime()
+
+	d.Worktime = portions.Worktime
+	d.Breaktime = portions.Breaktime
+
+	var startedOnDay Activities
+	for _, activity := range d.Activities {
+		if !activity.StartedAt.Before(dayStart) {
+			startedOnDay = append(startedOnDay, activity)
+		}
+	}
+	d.RoundedRuntime = startedOnDay.CalculateRoundedRuntime()
 }
 
 func (d *DailyActivities) CalculateWorktime() {
@@ -103,13 +294,15 @@ func (d *DailyActivities) CalculateWorktime() {
 	d.Worktime = uint64(endedAt.Sub(startedAt).Seconds())
 }
 
+// CalculateBreaktime sums up all gaps after paused activities, which are
+// explicit breaks, and adds the longest of the remaining gaps.
 func (d *DailyActivities) CalculateBreaktime() {
 	if len(d.Activities) < 2 {
 		d.Breaktime = 0
 		return
 	}
 
-	var maxBreak uint64
+	var maxBreak, pauses uint64
 	for i := 1; i < len(d.Activities); i++ {
 		prev := d.Activities[i-1]
 		curr := d.Activities[i]
@@ -119,44 +312,118 @@ func (d *DailyActivities) CalculateBreaktime() {
 			if curr.StartedAt.After(*prev.EndedAt) {
 				breakTime = uint64(curr.StartedAt.Sub(*prev.EndedAt).Seconds())
 			}
-			if breakTime > maxBreak {
+
+			switch {
+			case prev.Paused:
+				pauses += breakTime
+			case breakTime > maxBreak:
 				maxBreak = breakTime
 			}
 		}
 	}
 
-	d.Breaktime = maxBreak
+	d.Breaktime = maxBreak + pauses
 }
 
 type DbActivity struct {
-	ID          int
-	ProjectName string
-	StartedAt   time.Time
-	EndedAt     sql.NullTime
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	ID              int
+	ProjectName     string
+	StartedAt       time.Time
+	EndedAt         sql.NullTime
+	AutoStopped     bool
+	LastSeenAt      sql.NullTime
+	Paused          bool
+	PlannedDuration sql.NullInt64
+	// the rounding rule applying to the activity
+	RoundingMode      sql.NullString
+	RoundingIncrement sql.NullInt64
+	RoundingMinimum   sql.NullInt64
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
 }
 
-func (a *DbActivity) ToDomain() *Activity {
+func (a *DbActivity) ToDomain(location *time.Location) *Activity {
 	activity := &Activity{
 		ID:          a.ID,
 		ProjectName: a.ProjectName,
-		StartedAt:   a.StartedAt.Local(),
-		CreatedAt:   a.CreatedAt.Local(),
-		UpdatedAt:   a.UpdatedAt.Local(),
+		StartedAt:   a.StartedAt.In(location),
+		AutoStopped: a.AutoStopped,
+		Paused:      a.Paused,
+		CreatedAt:   a.CreatedAt.In(location),
+		UpdatedAt:   a.UpdatedAt.In(location),
 	}
 
 	if a.EndedAt.Valid && !a.EndedAt.Time.IsZero() {
-		localEndedAt := a.EndedAt.Time.Local()
+		localEndedAt := a.EndedAt.Time.In(location)
 		activity.EndedAt = &localEndedAt
 	}
 
+	if a.LastSeenAt.Valid {
+		localLastSeenAt := a.LastSeenAt.Time.In(location)
+		activity.LastSeenAt = &localLastSeenAt
+	}
+
+	if a.PlannedDuration.Valid {
+		plannedDuration := uint64(a.PlannedDuration.Int64)
+		plannedEndAt := activity.StartedAt.Add(time.Duration(plannedDuration) * time.Second)
+		activity.PlannedDurationInSeconds = &plannedDuration
+		activity.PlannedEndAt = &plannedEndAt
+
+		actualEndAt := time.Now()
+		if activity.EndedAt != nil {
+			actualEndAt = *activity.EndedAt
+		}
+		activity.Overrun = actualEndAt.After(plannedEndAt)
+	}
+
+	if rule := rounding.FromNullable(a.RoundingMode, a.RoundingIncrement, a.RoundingMinimum); rule != nil && activity.EndedAt != nil {
+		roundedDuration := rule.Round(uint64(activity.EndedAt.Sub(activity.StartedAt).Seconds()))
+		activity.RoundedDurationInSeconds = &roundedDuration
+	}
+
 	return activity
 }
 
+// DayBounds returns the start of the calendar day of day in the given location
+// and the start of the following day.
+func DayBounds(day time.Time, location *time.Location) (time.Time, time.Time) {
+	year, month, date := day.Date()
+	start := time.Date(year, month, date, 0, 0, 0, 0, location)
+
+	return start, start.AddDate(0, 0, 1)
+}
+
 type Worktime struct {
 	UserID    string
 	Day       time.Time
 	Worktime  uint
 	Breaktime uint
 }
+
+func (w *Worktime) Overtime() int64 {
+	return int64(w.Worktime) - int64(w.Breaktime) - WorkHours
+}
+
+// WorktimeDiff describes a day, whose stored worktime differs from the one
+// calculated from the activities. A nil worktime means there is none.
+type WorktimeDiff struct {
+	UserID   string
+	Day      time.Time
+	Stored   *Worktime
+	Expected *Worktime
+}
+
+func (d *WorktimeDiff) Differs() bool {
+	switch {
+	case d.Stored == nil || d.Expected == nil:
+		return d.Stored != d.Expected
+	default:
+		return d.Stored.Worktime != d.Expected.Worktime || d.Stored.Breaktime != d.Expected.Breaktime
+	}
+}
+
+type OvertimeDrift struct {
+	UserID   string
+	Stored   int64
+	Expected int64
+}
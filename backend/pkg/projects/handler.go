@@ -3,9 +3,12 @@ package projects
 import (
 	"context"
 	"errors"
+	"fmt"
 	"math"
+	"time"
 
 	"github.com/DominikKuenkele/TimeTrack/libraries/logger"
+	"github.com/DominikKuenkele/TimeTrack/libraries/rounding"
 	"github.com/DominikKuenkele/TimeTrack/libraries/user"
 )
 
@@ -16,21 +19,43 @@ type Handler interface {
 	GetAllLike(ctx context.Context, searchTerm string) ([]*Project, error)
 	GetPaginatedLike(ctx context.Context, page, perPage int, searchTerm string) (*PaginatedProjects, error)
 	Delete(ctx context.Context, name string) error
-	StartProject(ctx context.Context, name string) error
-	StopProject(ctx context.Context, name string) error
+	StartProject(ctx context.Context, name string, startedAt *time.Time, focus *Focus) error
+	StopProject(ctx context.Context, name string, endedAt *time.Time, discardIdle bool) (*StopResult, error)
+	PauseProject(ctx context.Context, name string, pausedAt *time.Time) (*Activity, error)
+	ResumeProject(ctx context.Context, name string, resumedAt *time.Time) error
+	Heartbeat(ctx context.Context, name string) error
+	GetFocusStatistics(ctx context.Context, name string) (*FocusStatistics, error)
+	ReserveIdempotencyKey(ctx context.Context, key, request string) (*IdempotentResponse, error)
+	SaveIdempotentResponse(ctx context.Context, key string, response *IdempotentResponse) error
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+	GetRounding(ctx context.Context, name string) (*rounding.Rule, error)
+	SetRounding(ctx context.Context, name string, rule *rounding.Rule) error
 }
 
+const (
+	// idempotencyPollInterval is the interval, in which a retried request
+	// checks, whether the first request with the same key has finished.
+	idempotencyPollInterval = 100 * time.Millisecond
+	// idempotencyPendingTimeout is the time after which a pending key is
+	// considered abandoned, e.g. because the server crashed.
+	idempotencyPendingTimeout = time.Minute
+)
+
 type handlerImpl struct {
-	logger     logger.Logger
-	repository Repository
+	logger            logger.Logger
+	repository        Repository
+	idleThreshold     time.Duration
+	idempotencyWindow time.Duration
 }
 
 var _ Handler = &handlerImpl{}
 
-func NewHandler(l logger.Logger, repository Repository) Handler {
+func NewHandler(l logger.Logger, repository Repository, idleThreshold, idempotencyWindow time.Duration) Handler {
 	return &handlerImpl{
-		logger:     l,
-		repository: repository,
+		logger:            l,
+		repository:        repository,
+		idleThreshold:     idleThreshold,
+		idempotencyWindow: idempotencyWindow,
 	}
 }
 
@@ -50,6 +75,79 @@ func (h *handlerImpl) Delete(ctx context.Context, name string) error {
 	return h.repository.DeleteProject(user.FromContext(ctx), name)
 }
 
+// ReserveIdempotencyKey reserves the idempotency key for the request and
+// returns nil, if the request should be run. If an earlier request with the
+// same key is still running, it waits for it and returns its response.
+func (h *handlerImpl) ReserveIdempotencyKey(ctx context.Context, key, request string) (*IdempotentResponse, error) {
+	userID := user.FromContext(ctx)
+	for {
+		now := time.Now()
+		reserved, err := h.repository.ReserveIdempotencyKey(userID, key, request, now.Add(-h.idempotencyWindow), now.Add(-idempotencyPendingTimeout))
+		if err != nil {
+			return nil, err
+		}
+		if reserved {
+			return nil, nil
+		}
+
+		response, err := h.repository.GetIdempotentResponse(userID, key, now.Add(-h.idempotencyWindow))
+		if err != nil {
+			return nil, err
+		}
+
+		// the response is nil, if the earlier request failed in between
+		if response != nil {
+			if response.Request != request {
+				return nil, fmt.Errorf("idempotency key '%s' was already used for another request", key)
+			}
+
+			if !response.Pending {
+				return response, nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(idempotencyPollInterval):
+		}
+	}
+}
+
+func (h *handlerImpl) SaveIdempotentResponse(ctx context.Context, key string, response *IdempotentResponse) error {
+	return h.repository.SaveIdempotentResponse(user.FromContext(ctx), key, response)
+}
+
+// ReleaseIdempotencyKey removes the reservation of a failed request, so that it
+// can be retried.
+func (h *handlerImpl) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	return h.repository.ReleaseIdempotencyKey(user.FromContext(ctx), key)
+}
+
+func (h *handlerImpl) GetRounding(ctx context.Context, name string) (*rounding.Rule, error) {
+	if name == "" {
+		return nil, errors.New("name must not be empty")
+	}
+
+	return h.repository.GetRounding(user.FromContext(ctx), name)
+}
+
+// SetRounding sets the rounding rule of the project, which overrides the one
+// of the user. A nil rule removes it.
+func (h *handlerImpl) SetRounding(ctx context.Context, name string, rule *rounding.Rule) error {
+	if name == "" {
+		return errors.New("name must not be empty")
+	}
+
+	if rule != nil {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return h.repository.SetRounding(user.FromContext(ctx), name, rule)
+}
+
 func (h *handlerImpl) GetAll(ctx context.Context) ([]*Project, error) {
 	return h.repository.GetProjectsLike(user.FromContext(ctx), "")
 }
@@ -74,7 +172,7 @@ func (h *handlerImpl) GetPaginatedLike(ctx context.Context, page, perPage int, s
 	var remainingProjects []*Project
 
 	for _, project := range allProjects {
-		if project.StartedAt != nil {
+		if project.StartedAt != nil || (project.PausedAt != nil && activeProject == nil) {
 			activeProject = project
 		} else {
 			remainingProjects = append(remainingProjects, project)
@@ -111,18 +209,156 @@ func (h *handlerImpl) Get(ctx context.Context, name string) (*Project, error) {
 	return h.repository.GetProject(user.FromContext(ctx), name)
 }
 
-func (h *handlerImpl) StartProject(ctx context.Context, name string) error {
+// StartProject starts the project at startedAt, which allows backdating the
+// start. If startedAt is nil, the project is started now. If focus is given, a
+// focus session with a planned duration is started.
+func (h *handlerImpl) StartProject(ctx context.Context, name string, startedAt *time.Time, focus *Focus) error {
+	if name == "" {
+		return errors.New("name must not be empty")
+	}
+
+	start, err := pastOrNow(startedAt, "startedAt")
+	if err != nil {
+		return err
+	}
+
+	if focus != nil && focus.PlannedDuration < time.Second {
+		return errors.New("planned duration must be at least one second")
+	}
+
+	return h.repository.StartProject(user.FromContext(ctx), name, start, focus)
+}
+
+// StopProject stops the project at endedAt, which allows backdating the stop.
+// If endedAt is nil, the project is stopped now. If no heartbeat was received
+// for longer than the idle threshold before stopping, the idle time is either
+// discarded right away or offered to be discarded in the result.
+func (h *handlerImpl) StopProject(ctx context.Context, name string, endedAt *time.Time, discardIdle bool) (*StopResult, error) {
+	if name == "" {
+		return nil, errors.New("name must not be empty")
+	}
+
+	end, err := pastOrNow(endedAt, "endedAt")
+	if err != nil {
+		return nil, err
+	}
+
+	userID := user.FromContext(ctx)
+
+	project, err := h.repository.GetProject(userID, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// a paused project has no running activity, stopping it only ends the pause
+	if project.StartedAt == nil && project.PausedAt != nil {
+		if err := h.repository.EndPause(userID, name); err != nil {
+			return nil, err
+		}
+
+		return &StopResult{}, nil
+	}
+
+	// the idle time is discarded within the same transaction as the stop
+	var discardIdleAfter *time.Duration
+	if discardIdle {
+		discardIdleAfter = &h.idleThreshold
+	}
+
+	activity, err := h.repository.StopProject(userID, name, end, discardIdleAfter)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &StopResult{
+		Activity: activity,
+	}
+
+	if activity.LastSeenAt == nil || end.Sub(*activity.LastSeenAt) <= h.idleThreshold {
+		return result, nil
+	}
+
+	result.IdleSince = activity.LastSeenAt
+	result.IdleSeconds = uint64(end.Sub(*activity.LastSeenAt).Seconds())
+	result.IdleDiscarded = discardIdle
+
+	return result, nil
+}
+
+// PauseProject ends the current activity of the project at pausedAt, but keeps
+// the project as current project, so that it can be resumed later. If
+// pausedAt is nil, the project is paused now.
+func (h *handlerImpl) PauseProject(ctx context.Context, name string, pausedAt *time.Time) (*Activity, error) {
+	if name == "" {
+		return nil, errors.New("name must not be empty")
+	}
+
+	pause, err := pastOrNow(pausedAt, "pausedAt")
+	if err != nil {
+		return nil, err
+	}
+
+	return h.repository.PauseProject(user.FromContext(ctx), name, pause)
+}
+
+// ResumeProject starts a new activity of the paused project at resumedAt. If
+// resumedAt is nil, the project is resumed now.
+func (h *handlerImpl) ResumeProject(ctx context.Context, name string, resumedAt *time.Time) error {
 	if name == "" {
 		return errors.New("name must not be empty")
 	}
 
-	return h.repository.StartProject(user.FromContext(ctx), name)
+	resume, err := pastOrNow(resumedAt, "resumedAt")
+	if err != nil {
+		return err
+	}
+
+	userID := user.FromContext(ctx)
+
+	project, err := h.repository.GetProject(userID, name)
+	if err != nil {
+		return err
+	}
+
+	if project.PausedAt == nil {
+		return errors.New("project not paused")
+	}
+
+	return h.repository.StartProject(userID, name, resume, nil)
 }
 
-func (h *handlerImpl) StopProject(ctx context.Context, name string) error {
+func (h *handlerImpl) Heartbeat(ctx context.Context, name string) error {
 	if name == "" {
 		return errors.New("name must not be empty")
 	}
 
-	return h.repository.StopProject(user.FromContext(ctx), name)
+	return h.repository.Heartbeat(user.FromContext(ctx), name)
+}
+
+// GetFocusStatistics compares the planned and actual durations of the finished
+// focus sessions of the project.
+func (h *handlerImpl) GetFocusStatistics(ctx context.Context, name string) (*FocusStatistics, error) {
+	if name == "" {
+		return nil, errors.New("name must not be empty")
+	}
+
+	project, err := h.repository.GetProject(user.FromContext(ctx), name)
+	if err != nil {
+		return nil, err
+	}
+
+	return project.Activities.CalculateFocusStatistics(), nil
+}
+
+func pastOrNow(t *time.Time, field string) (time.Time, error) {
+	now := time.Now()
+	if t == nil {
+		return now, nil
+	}
+
+	if t.After(now) {
+		return time.Time{}, fmt.Errorf("%s must be in the past", field)
+	}
+
+	return *t, nil
 }
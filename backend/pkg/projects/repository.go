@@ -9,6 +9,7 @@ import (
 
 	"github.com/DominikKuenkele/TimeTrack/libraries/database"
 	"github.com/DominikKuenkele/TimeTrack/libraries/logger"
+	"github.com/DominikKuenkele/TimeTrack/libraries/rounding"
 	"github.com/DominikKuenkele/TimeTrack/libraries/utilitites"
 	"github.com/lib/pq"
 )
@@ -19,11 +20,35 @@ type Repository interface {
 	GetRunningProject(userID string) (*Project, error)
 	GetProjectsLike(userID, searchTerm string) ([]*Project, error)
 	DeleteProject(userID, name string) error
-	StartProject(userID, name string) error
-	StopProject(userID, name string) error
+	GetRounding(userID, name string) (*rounding.Rule, error)
+	ReserveIdempotencyKey(userID, key, request string, notBefore, pendingNotBefore time.Time) (bool, error)
+	GetIdempotentResponse(userID, key string, notBefore time.Time) (*IdempotentResponse, error)
+	SaveIdempotentResponse(userID, key string, response *IdempotentResponse) error
+	ReleaseIdempotencyKey(userID, key string) error
+	DeleteExpiredIdempotencyKeys(notBefore time.Time) (int64, error)
+	SetRounding(userID, name string, rule *rounding.Rule) error
+	StartProject(userID, name string, startedAt time.Time, focus *Focus) error
+	StopProject(userID, name string, endedAt time.Time, discardIdleAfter *time.Duration) (*Activity, error)
+	PauseProject(userID, name string, pausedAt time.Time) (*Activity, error)
+	EndPause(userID, name string) error
+	Heartbeat(userID, name string) error
+	AutoStopProject(userID, name string, endedAt time.Time) error
+	StopFocusSession(userID, name string, plannedEndAt time.Time) error
+	GetElapsedFocusSessions() ([]*ElapsedFocusSession, error)
+	GetRunningProjects() ([]*Project, error)
+	GetMaxTimerDuration(userID string) (*time.Duration, error)
+	GetLocation(userID string) (*time.Location, error)
 	GetActivities(userID string, day time.Time) (Activities, error)
-	ChangeActivity(userID string, activity Activity) error
+	ChangeActivity(userID string, activity Activity, trimNeighbours bool) error
+	DiscardIdle(userID string, activityID int) error
+	SplitActivity(userID string, activityID int, splitAt time.Time, projectName string) (int, error)
+	MergeActivity(userID string, activityID int) error
 	GetWorktime(userID string) ([]*Worktime, error)
+	RebuildWorktime(userID string, days []time.Time, dryRun bool) ([]*WorktimeDiff, error)
+	RebuildAllWorktime(userID string) error
+	GetTrackingUserIDs() ([]string, error)
+	GetOvertime(userID string) (int64, error)
+	SetOvertime(userID string, balance int64) error
 }
 
 type repositoryImpl struct {
@@ -44,30 +69,164 @@ func NewRepository(logger logger.Logger, database database.Database) (Repository
 	}, nil
 }
 
+// rebuildBatchSize is the number of days rebuilt within one transaction, when
+// all worktime of a user is rebuilt.
+const rebuildBatchSize = 31
+
 const (
 	tableProjects           = "projects"
 	columnProjectsProjectID = "project_id"
 	columnProjectsUserID    = "user_id"
 	columnProjectsName      = "name"
 	columnProjectsStartedAt = "started_at"
+	columnProjectsPausedAt  = "paused_at"
 	columnProjectsCreatedAt = "created_at"
 	columnProjectsUpdatedAt = "updated_at"
 
-	tableActvities              = "activities"
-	columnsActivitiesActivityID = "activity_id"
-	columnsActivitiesProjectID  = "project_id"
-	columnsActivitiesStartedAt  = "started_at"
-	columnsActivitiesEndedAt    = "ended_at"
-	columnsActivitiesCreatedAt  = "created_at"
-	columnsActivitiesUpdatedAt  = "updated_at"
+	tableActvities                   = "activities"
+	columnsActivitiesActivityID      = "activity_id"
+	columnsActivitiesUserID          = "user_id"
+	columnsActivitiesProjectID       = "project_id"
+	columnsActivitiesStartedAt       = "started_at"
+	columnsActivitiesEndedAt         = "ended_at"
+	columnsActivitiesAutoStopped     = "auto_stopped"
+	columnsActivitiesLastSeenAt      = "last_seen_at"
+	columnsActivitiesPaused          = "paused"
+	columnsActivitiesPlannedDuration = "planned_duration"
+	columnsActivitiesFocusAutoStop   = "focus_auto_stop"
+	columnsActivitiesCreatedAt       = "created_at"
+	columnsActivitiesUpdatedAt       = "updated_at"
 
 	tableWorktime           = "worktime"
 	columnWorktimeUserID    = "user_id"
 	columnWorktimeDay       = "day"
 	columnWorktimeWorktime  = "work_time"
 	columnWorktimeBreaktime = "break_time"
+
+	tableOvertime         = "overtime"
+	columnOvertimeUserID  = "user_id"
+	columnOvertimeBalance = "balance"
+
+	tableIdempotencyKeys              = "idempotency_keys"
+	columnIdempotencyKeysUserID       = "user_id"
+	columnIdempotencyKeysKey          = "idempotency_key"
+	columnIdempotencyKeysRequest      = "request"
+	columnIdempotencyKeysStatusCode   = "status_code"
+	columnIdempotencyKeysResponseBody = "response_body"
+	columnIdempotencyKeysCreatedAt    = "created_at"
+
+	tableUsers                  = "users"
+	columnUsersUserID           = "user_id"
+	columnUsersTimeZone         = "time_zone"
+	columnUsersMaxTimerDuration = "max_timer_duration"
+
+	// the rounding columns exist on users and projects, where a rule of the
+	// project overrides the one of the user
+	columnRoundingMode      = "rounding_mode"
+	columnRoundingIncrement = "rounding_increment"
+	columnRoundingMinimum   = "rounding_minimum"
+
+	// selectEffectiveRounding selects the rounding rule applying to an activity
+	// of project p of user u
+	selectEffectiveRounding = "COALESCE(p." + columnRoundingMode + ", u." + columnRoundingMode + ")" +
+		", COALESCE(p." + columnRoundingIncrement + ", u." + columnRoundingIncrement + ")" +
+		", COALESCE(p." + columnRoundingMinimum + ", u." + columnRoundingMinimum + ")"
 )
 
+// GetLocation returns the time zone of the user, which determines the
+// boundaries of their days. Unknown users fall back to UTC.
+func (r *repositoryImpl) GetLocation(userID string) (*time.Location, error) {
+	var timeZone string
+	if err := r.database.QueryRow(
+		"SELECT "+columnUsersTimeZone+
+			" FROM "+tableUsers+
+			" WHERE "+columnUsersUserID+"=$1;",
+		[]any{userID},
+		&timeZone,
+	); err != nil {
+		switch {
+		case errors.As(err, &database.NoRowsError{}):
+			return time.UTC, nil
+		default:
+			return nil, r.logger.LogAndAbstractError("database error", "Error reading time zone: %+v", err)
+		}
+	}
+
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return nil, r.logger.LogAndAbstractError("invalid time zone", "Couldn't load time zone '%s' of user '%s': %+v", timeZone, userID, err)
+	}
+
+	return location, nil
+}
+
+// inTransaction runs f within a new transaction, which is committed if f
+// succeeds and rolled back otherwise.
+func (r *repositoryImpl) inTransaction(action string, f func(tx *sql.Tx) error) error {
+	tx, err := r.database.Begin()
+	if err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't create transaction: %+v", err)
+	}
+
+	if err := f(tx); err != nil {
+		r.logger.Info("Rolling back transaction while %s", action)
+		if err := tx.Rollback(); err != nil {
+			r.logger.Error("Failed to rollback transaction: %+v", err)
+		}
+
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't commit transaction while %s: %+v", action, err)
+	}
+
+	return nil
+}
+
+// query runs the query within the transaction, if it is given.
+func (r *repositoryImpl) query(tx *sql.Tx, query string, args ...any) (*sql.Rows, error) {
+	if tx == nil {
+		return r.database.Query(query, args...)
+	}
+
+	return r.database.QueryWithTx(tx, query, args...)
+}
+
+// queryRow runs the query within the transaction, if it is given.
+func (r *repositoryImpl) queryRow(tx *sql.Tx, query string, args []any, dest ...any) error {
+	if tx == nil {
+		return r.database.QueryRow(query, args, dest...)
+	}
+
+	return r.database.QueryRowWithTx(tx, query, args, dest...)
+}
+
+// lockUserWithTx locks the user until the end of the transaction. Every change
+// of the running project of a user takes this lock first, so that concurrent
+// requests of the same user are serialized.
+func (r *repositoryImpl) lockUserWithTx(tx *sql.Tx, userID string) error {
+	var lockedUserID string
+	if err := r.database.QueryRowWithTx(
+		tx,
+		"SELECT "+columnUsersUserID+
+			" FROM "+tableUsers+
+			" WHERE "+columnUsersUserID+"=$1"+
+			" FOR UPDATE;",
+		[]any{userID},
+		&lockedUserID,
+	); err != nil {
+		switch {
+		case errors.As(err, &database.NoRowsError{}):
+			return fmt.Errorf("user '%s' not found", userID)
+		default:
+			return r.logger.LogAndAbstractError("database error", "Couldn't lock user: %+v", err)
+		}
+	}
+
+	return nil
+}
+
 func (r *repositoryImpl) AddProject(userID, name string) error {
 	_, err := r.database.Exec(
 		"INSERT"+
@@ -88,10 +247,15 @@ func (r *repositoryImpl) AddProject(userID, name string) error {
 }
 
 func (r *repositoryImpl) GetProjectsLike(userID, searchTerm string) ([]*Project, error) {
+	location, err := r.GetLocation(userID)
+	if err != nil {
+		return nil, err
+	}
+
 	searchTerm = "%" + searchTerm + "%"
 
 	rows, err := r.database.Query(
-		"SELECT "+columnProjectsProjectID+", "+columnProjectsUserID+", "+columnProjectsName+", "+columnProjectsStartedAt+", "+columnProjectsCreatedAt+", "+columnProjectsUpdatedAt+
+		"SELECT "+columnProjectsProjectID+", "+columnProjectsUserID+", "+columnProjectsName+", "+columnProjectsStartedAt+", "+columnProjectsPausedAt+", "+columnProjectsCreatedAt+", "+columnProjectsUpdatedAt+
 			" FROM "+tableProjects+
 			" WHERE "+columnProjectsUserID+"=$1 AND "+columnProjectsName+" ILIKE $2"+
 			" ORDER BY "+columnProjectsUpdatedAt+" DESC;",
@@ -111,38 +275,43 @@ func (r *repositoryImpl) GetProjectsLike(userID, searchTerm string) ([]*Project,
 			&project.UserID,
 			&project.Name,
 			&project.StartedAt,
+			&project.PausedAt,
 			&project.CreatedAt,
 			&project.UpdatedAt,
 		); err != nil {
 			return nil, r.logger.LogAndAbstractError("database error", "Error scanning project: %+v", err)
 		}
 
-		projectMap[project.ID] = project.ToDomain()
+		projectMap[project.ID] = project.ToDomain(location)
 		projectOrder = append(projectOrder, project.ID)
 	}
 
-	if err = r.readActivities(projectMap); err != nil {
+	if err = r.readActivities(nil, projectMap, location); err != nil {
 		return nil, err
 	}
 
 	projects := make([]*Project, 0, len(projectOrder))
 	for _, projectID := range projectOrder {
 		projectMap[projectID].RuntimeInSeconds = projectMap[projectID].Activities.CalculateRuntime()
+		projectMap[projectID].RemainingSeconds = projectMap[projectID].Activities.CalculateRemainingFocus()
+		projectMap[projectID].RoundedRuntimeInSeconds = projectMap[projectID].Activities.CalculateRoundedRuntime()
 		projects = append(projects, projectMap[projectID])
 	}
 
 	return projects, nil
 }
 
-func (r *repositoryImpl) readActivities(projects map[int]*Project) error {
+func (r *repositoryImpl) readActivities(tx *sql.Tx, projects map[int]*Project, location *time.Location) error {
 	projectIDs := utilitites.MapKeysToSlice(projects)
 
-	rows, err := r.database.Query(
+	rows, err := r.query(
+		tx,
 		"SELECT"+
-			" a."+columnsActivitiesActivityID+", a."+columnsActivitiesProjectID+", a."+columnsActivitiesStartedAt+", a."+columnsActivitiesEndedAt+", a."+columnsActivitiesCreatedAt+", a."+columnsActivitiesUpdatedAt+
-			", p."+columnProjectsName+
+			" a."+columnsActivitiesActivityID+", a."+columnsActivitiesProjectID+", a."+columnsActivitiesStartedAt+", a."+columnsActivitiesEndedAt+", a."+columnsActivitiesAutoStopped+", a."+columnsActivitiesLastSeenAt+", a."+columnsActivitiesPaused+", a."+columnsActivitiesPlannedDuration+", a."+columnsActivitiesCreatedAt+", a."+columnsActivitiesUpdatedAt+
+			", p."+columnProjectsName+", "+selectEffectiveRounding+
 			" FROM "+tableActvities+" a"+
 			" LEFT JOIN "+tableProjects+" p ON a."+columnsActivitiesProjectID+"=p."+columnProjectsProjectID+
+			" LEFT JOIN "+tableUsers+" u ON p."+columnProjectsUserID+"=u."+columnUsersUserID+
 			" WHERE a."+columnsActivitiesProjectID+"=ANY($1);",
 		pq.Array(projectIDs),
 	)
@@ -159,24 +328,32 @@ func (r *repositoryImpl) readActivities(projects map[int]*Project) error {
 			&projectID,
 			&activity.StartedAt,
 			&activity.EndedAt,
+			&activity.AutoStopped,
+			&activity.LastSeenAt,
+			&activity.Paused,
+			&activity.PlannedDuration,
 			&activity.CreatedAt,
 			&activity.UpdatedAt,
 			&activity.ProjectName,
+			&activity.RoundingMode,
+			&activity.RoundingIncrement,
+			&activity.RoundingMinimum,
 		); err != nil {
 			return r.logger.LogAndAbstractError("database error", "Error scanning activities: %+v", err)
 		}
 
-		projects[projectID].Activities = append(projects[projectID].Activities, activity.ToDomain())
+		projects[projectID].Activities = append(projects[projectID].Activities, activity.ToDomain(location))
 	}
 
 	return nil
 }
 
-func (r *repositoryImpl) readSingleProject(whereClause string, args []any) (*Project, error) {
-	project := &Project{}
-	err := r.database.QueryRow(
+func (r *repositoryImpl) readSingleProject(tx *sql.Tx, whereClause string, args []any, location *time.Location) (*Project, error) {
+	project := &DbProject{}
+	err := r.queryRow(
+		tx,
 		"SELECT"+
-			" "+columnProjectsProjectID+", "+columnProjectsUserID+", "+columnProjectsName+", "+columnProjectsStartedAt+", "+columnProjectsCreatedAt+", "+columnProjectsUpdatedAt+
+			" "+columnProjectsProjectID+", "+columnProjectsUserID+", "+columnProjectsName+", "+columnProjectsStartedAt+", "+columnProjectsPausedAt+", "+columnProjectsCreatedAt+", "+columnProjectsUpdatedAt+
 			" FROM "+tableProjects+
 			" "+whereClause+
 			" LIMIT 1;",
@@ -185,6 +362,7 @@ func (r *repositoryImpl) readSingleProject(whereClause string, args []any) (*Pro
 		&project.UserID,
 		&project.Name,
 		&project.StartedAt,
+		&project.PausedAt,
 		&project.CreatedAt,
 		&project.UpdatedAt,
 	)
@@ -192,13 +370,26 @@ func (r *repositoryImpl) readSingleProject(whereClause string, args []any) (*Pro
 		return nil, err
 	}
 
-	return project, nil
+	return project.ToDomain(location), nil
 }
 
 func (r *repositoryImpl) GetProject(userID, name string) (*Project, error) {
+	return r.getProjectWithTx(nil, userID, name)
+}
+
+// getProjectWithTx reads the project like GetProject, but within the
+// transaction, if it is given.
+func (r *repositoryImpl) getProjectWithTx(tx *sql.Tx, userID, name string) (*Project, error) {
+	location, err := r.GetLocation(userID)
+	if err != nil {
+		return nil, err
+	}
+
 	project, err := r.readSingleProject(
+		tx,
 		"WHERE "+columnProjectsUserID+"=$1 AND "+columnProjectsName+"=$2",
 		[]any{userID, name},
+		location,
 	)
 	if err != nil {
 		switch {
@@ -213,19 +404,34 @@ func (r *repositoryImpl) GetProject(userID, name string) (*Project, error) {
 		project.ID: project,
 	}
 
-	if err = r.readActivities(projectMap); err != nil {
+	if err = r.readActivities(tx, projectMap, location); err != nil {
 		return nil, err
 	}
 
 	project.RuntimeInSeconds = project.Activities.CalculateRuntime()
+	project.RemainingSeconds = project.Activities.CalculateRemainingFocus()
+	project.RoundedRuntimeInSeconds = project.Activities.CalculateRoundedRuntime()
 
 	return project, nil
 }
 
 func (r *repositoryImpl) GetRunningProject(userID string) (*Project, error) {
+	return r.getRunningProjectWithTx(nil, userID)
+}
+
+// getRunningProjectWithTx reads the running project like GetRunningProject,
+// but within the transaction, if it is given.
+func (r *repositoryImpl) getRunningProjectWithTx(tx *sql.Tx, userID string) (*Project, error) {
+	location, err := r.GetLocation(userID)
+	if err != nil {
+		return nil, err
+	}
+
 	project, err := r.readSingleProject(
+		tx,
 		"WHERE "+columnProjectsUserID+"=$1 AND "+columnProjectsStartedAt+" IS NOT NULL",
 		[]any{userID},
+		location,
 	)
 	if err != nil {
 		switch {
@@ -240,283 +446,1626 @@ func (r *repositoryImpl) GetRunningProject(userID string) (*Project, error) {
 		project.ID: project,
 	}
 
-	if err = r.readActivities(projectMap); err != nil {
+	if err = r.readActivities(tx, projectMap, location); err != nil {
 		return nil, err
 	}
 
 	project.RuntimeInSeconds = project.Activities.CalculateRuntime()
+	project.RemainingSeconds = project.Activities.CalculateRemainingFocus()
+	project.RoundedRuntimeInSeconds = project.Activities.CalculateRoundedRuntime()
 
 	return project, nil
 }
 
-func (r *repositoryImpl) DeleteProject(userID, name string) error {
-	res, err := r.database.Exec(
-		"DELETE"+
-			" FROM "+tableProjects+
-			" WHERE "+columnProjectsUserID+"=$1 AND "+columnProjectsName+"=$2;",
-		userID, name)
-	if err != nil {
-		return r.logger.LogAndAbstractError("database error", "Couldn't delete project: %+v", err)
-	}
-
-	if rows, _ := res.RowsAffected(); rows != 1 {
-		r.logger.Error("Couldn't find and delete project: %s", name)
-
-		return &database.NoRowsError{
-			Message: fmt.Sprintf("project '%s' not found", name),
+// ReserveIdempotencyKey stores a pending response for the idempotency key of
+// the user and reports, whether the key was free. Keys created before
+// notBefore and pending ones created before pendingNotBefore are taken over.
+func (r *repositoryImpl) ReserveIdempotencyKey(userID, key, request string, notBefore, pendingNotBefore time.Time) (bool, error) {
+	reserved := false
+	err := r.inTransaction("reserving idempotency key", func(tx *sql.Tx) error {
+		if _, err := r.database.ExecWithTx(
+			tx,
+			"DELETE FROM "+tableIdempotencyKeys+
+				" WHERE "+columnIdempotencyKeysUserID+"=$1 AND "+columnIdempotencyKeysKey+"=$2"+
+				" AND ("+columnIdempotencyKeysCreatedAt+"<$3 OR ("+columnIdempotencyKeysStatusCode+" IS NULL AND "+columnIdempotencyKeysCreatedAt+"<$4));",
+			userID, key, notBefore, pendingNotBefore,
+		); err != nil {
+			return r.logger.LogAndAbstractError("database error", "Couldn't remove expired idempotency key: %+v", err)
 		}
-	}
-
-	return nil
-}
-
-func (r *repositoryImpl) StartProject(userID, name string) error {
-	project, err := r.GetProject(userID, name)
-	if err != nil {
-		return err
-	}
 
-	if project.StartedAt != nil {
-		return fmt.Errorf("project already started")
-	}
-
-	runningProject, err := r.GetRunningProject(userID)
-	if err != nil {
-		return err
-	}
+		result, err := r.database.ExecWithTx(
+			tx,
+			"INSERT INTO "+tableIdempotencyKeys+
+				" ("+columnIdempotencyKeysUserID+", "+columnIdempotencyKeysKey+", "+columnIdempotencyKeysRequest+")"+
+				" VALUES ($1, $2, $3)"+
+				" ON CONFLICT ("+columnIdempotencyKeysUserID+", "+columnIdempotencyKeysKey+") DO NOTHING;",
+			userID, key, request,
+		)
+		if err != nil {
+			return r.logger.LogAndAbstractError("database error", "Couldn't reserve idempotency key: %+v", err)
+		}
 
-	tx, err := r.database.Begin()
-	if err != nil {
-		return r.logger.LogAndAbstractError("database error", "Couldn't create transaction: %+v", err)
-	}
-	defer func() {
-		if tx != nil {
-			r.logger.Info("Rolling back transaction while starting project")
-			if err := tx.Rollback(); err != nil {
-				r.logger.Error("Failed to rollback transaction: %+v", err)
-			}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return r.logger.LogAndAbstractError("database error", "Couldn't reserve idempotency key: %+v", err)
 		}
-	}()
+		reserved = affected == 1
 
-	if _, err := r.database.ExecWithTx(
-		tx,
-		"INSERT INTO "+tableActvities+
-			" ("+columnsActivitiesProjectID+", "+columnsActivitiesStartedAt+")"+
-			" VALUES ($1, NOW());",
-		project.ID,
-	); err != nil {
-		return r.logger.LogAndAbstractError("database error", "Couldn't start project '%s': %+v", name, err)
-	}
+		return nil
+	})
 
-	if _, err := r.database.ExecWithTx(
-		tx,
-		"UPDATE "+tableProjects+
-			" SET "+columnProjectsStartedAt+"=NOW()"+
-			" WHERE "+columnProjectsProjectID+"=$1;",
-		project.ID,
-	); err != nil {
-		return r.logger.LogAndAbstractError("database error", "Couldn't start project '%s': %+v", name, err)
-	}
+	return reserved, err
+}
 
-	if runningProject != nil {
-		err = r.stopProjectWithTx(tx, runningProject.UserID, runningProject.Name)
-		if err != nil {
-			return err
+// GetIdempotentResponse returns the response stored for the idempotency key of
+// the user or nil, if there is none stored since notBefore.
+func (r *repositoryImpl) GetIdempotentResponse(userID, key string, notBefore time.Time) (*IdempotentResponse, error) {
+	var statusCode sql.NullInt64
+	response := &IdempotentResponse{}
+	if err := r.database.QueryRow(
+		"SELECT "+columnIdempotencyKeysRequest+", "+columnIdempotencyKeysStatusCode+", "+columnIdempotencyKeysResponseBody+
+			" FROM "+tableIdempotencyKeys+
+			" WHERE "+columnIdempotencyKeysUserID+"=$1 AND "+columnIdempotencyKeysKey+"=$2 AND "+columnIdempotencyKeysCreatedAt+">=$3;",
+		[]any{userID, key, notBefore},
+		&response.Request,
+		&statusCode,
+		&response.Body,
+	); err != nil {
+		switch {
+		case errors.As(err, &database.NoRowsError{}):
+			return nil, nil
+		default:
+			return nil, r.logger.LogAndAbstractError("database error", "Error scanning idempotent response: %+v", err)
 		}
 	}
 
-	tx.Commit()
-	tx = nil
-
-	return r.updateWorktime(userID, time.Now())
-}
+	response.Pending = !statusCode.Valid
+	response.StatusCode = int(statusCode.Int64)
 
-func (r *repositoryImpl) StopProject(userID, name string) error {
-	return r.stopProjectWithTx(nil, userID, name)
+	return response, nil
 }
 
-func (r *repositoryImpl) stopProjectWithTx(tx *sql.Tx, userID, name string) error {
-	project, err := r.GetProject(userID, name)
-	if err != nil {
-		return r.logger.LogAndAbstractError("database error", "Failed to fetch project '%s': %+v", name, err)
+// SaveIdempotentResponse stores the response for the reserved idempotency key
+// of the user.
+func (r *repositoryImpl) SaveIdempotentResponse(userID, key string, response *IdempotentResponse) error {
+	if _, err := r.database.Exec(
+		"UPDATE "+tableIdempotencyKeys+
+			" SET "+columnIdempotencyKeysStatusCode+"=$3, "+columnIdempotencyKeysResponseBody+"=$4"+
+			" WHERE "+columnIdempotencyKeysUserID+"=$1 AND "+columnIdempotencyKeysKey+"=$2 AND "+columnIdempotencyKeysStatusCode+" IS NULL;",
+		userID, key, response.StatusCode, response.Body,
+	); err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't save idempotent response: %+v", err)
 	}
 
-	if project.StartedAt == nil {
-		return fmt.Errorf("project not running")
-	}
+	return nil
+}
 
-	externalTx := tx != nil
-	if !externalTx {
-		tx, err = r.database.Begin()
-		if err != nil {
-			return r.logger.LogAndAbstractError("database error", "Couldn't create transaction: %+v", err)
-		}
-		defer func() {
-			if tx != nil {
-				r.logger.Info("Rolling back transaction while stopping project")
-				if err := tx.Rollback(); err != nil {
-					r.logger.Error("Failed to rollback transaction: %+v", err)
-				}
-			}
-		}()
+// ReleaseIdempotencyKey removes the reservation of the idempotency key of the
+// user, so that the request can be retried.
+func (r *repositoryImpl) ReleaseIdempotencyKey(userID, key string) error {
+	if _, err := r.database.Exec(
+		"DELETE FROM "+tableIdempotencyKeys+
+			" WHERE "+columnIdempotencyKeysUserID+"=$1 AND "+columnIdempotencyKeysKey+"=$2 AND "+columnIdempotencyKeysStatusCode+" IS NULL;",
+		userID, key,
+	); err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't release idempotency key: %+v", err)
 	}
 
-	_, err = r.database.ExecWithTx(
-		tx,
-		"UPDATE "+tableActvities+
-			" SET "+columnsActivitiesEndedAt+"=NOW()"+
-			" WHERE "+columnsActivitiesProjectID+"=$1 AND "+columnsActivitiesEndedAt+" IS NULL;",
-		project.ID,
-	)
-	if err != nil {
-		return r.logger.LogAndAbstractError("database error", "Couldn't stop project '%s': %+v", name, err)
-	}
+	return nil
+}
 
-	_, err = r.database.ExecWithTx(
-		tx,
-		"UPDATE "+tableProjects+
-			" SET "+columnProjectsStartedAt+"=NULL"+
-			" WHERE "+columnProjectsProjectID+"=$1;",
-		project.ID,
+// DeleteExpiredIdempotencyKeys removes the idempotency keys of all users
+// created before notBefore and returns their number.
+func (r *repositoryImpl) DeleteExpiredIdempotencyKeys(notBefore time.Time) (int64, error) {
+	result, err := r.database.Exec(
+		"DELETE FROM "+tableIdempotencyKeys+
+			" WHERE "+columnIdempotencyKeysCreatedAt+"<$1;",
+		notBefore,
 	)
 	if err != nil {
-		return r.logger.LogAndAbstractError("database error", "Couldn't stop project '%s': %+v", name, err)
+		return 0, r.logger.LogAndAbstractError("database error", "Couldn't remove expired idempotency keys: %+v", err)
 	}
 
-	if !externalTx {
-		tx.Commit()
-		tx = nil
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, r.logger.LogAndAbstractError("database error", "Couldn't remove expired idempotency keys: %+v", err)
 	}
 
-	return r.updateWorktime(userID, time.Now())
+	return deleted, nil
 }
 
-func (r *repositoryImpl) GetActivities(userID string, day time.Time) (Activities, error) {
-	rows, err := r.database.Query(
-		"SELECT"+
-			" a."+columnsActivitiesActivityID+", a."+columnsActivitiesStartedAt+", a."+columnsActivitiesEndedAt+", a."+columnsActivitiesCreatedAt+", a."+columnsActivitiesUpdatedAt+
-			", p."+columnProjectsName+
-			" FROM "+tableActvities+" a"+
-			" LEFT JOIN "+tableProjects+" p ON a."+columnsActivitiesProjectID+"=p."+columnProjectsProjectID+
-			" WHERE a."+columnsActivitiesStartedAt+"::date = $1 AND p."+columnProjectsUserID+"=$2"+
-			" ORDER BY a."+columnsActivitiesStartedAt+" ASC;",
-		day.Format("2006-01-02"),
-		userID,
+// GetRounding returns the rounding rule of the project itself or nil, if the
+// rule of the user applies.
+func (r *repositoryImpl) GetRounding(userID, name string) (*rounding.Rule, error) {
+	var (
+		mode      sql.NullString
+		increment sql.NullInt64
+		minimum   sql.NullInt64
 	)
-	if err != nil {
+	if err := r.database.QueryRow(
+		"SELECT "+columnRoundingMode+", "+columnRoundingIncrement+", "+columnRoundingMinimum+
+			" FROM "+tableProjects+
+			" WHERE "+columnProjectsUserID+"=$1 AND "+columnProjectsName+"=$2;",
+		[]any{userID, name},
+		&mode,
+		&increment,
+		&minimum,
+	); err != nil {
 		switch {
 		case errors.As(err, &database.NoRowsError{}):
-			return Activities{}, nil
+			return nil, fmt.Errorf("project %s not found", name)
 		default:
-			return nil, r.logger.LogAndAbstractError("database error", "Error getting activities: %+v", err)
-		}
-	}
-	defer rows.Close()
-
-	activitySlice := Activities{}
-	for rows.Next() {
-		activity := &DbActivity{}
-		if err := rows.Scan(
-			&activity.ID,
-			&activity.StartedAt,
-			&activity.EndedAt,
-			&activity.CreatedAt,
-			&activity.UpdatedAt,
-			&activity.ProjectName,
-		); err != nil {
-			return nil, r.logger.LogAndAbstractError("database error", "Error scanning activities: %+v", err)
+			return nil, r.logger.LogAndAbstractError("database error", "Error scanning rounding rule: %+v", err)
 		}
-
-		activitySlice = append(activitySlice, activity.ToDomain())
 	}
 
-	slices.SortFunc(activitySlice, func(a, b *Activity) int {
-		return a.StartedAt.Compare(b.StartedAt)
-	})
-
-	return activitySlice, nil
+	return rounding.FromNullable(mode, increment, minimum), nil
 }
 
-func (r *repositoryImpl) ChangeActivity(userID string, activity Activity) error {
-	project, err := r.GetProject(userID, activity.ProjectName)
-	if err != nil {
-		return err
-	}
+// SetRounding sets the rounding rule of the project. A nil rule removes it, so
+// that the rule of the user applies again.
+func (r *repositoryImpl) SetRounding(userID, name string, rule *rounding.Rule) error {
+	mode, increment, minimum := rule.ToNullable()
 
 	res, err := r.database.Exec(
-		"UPDATE "+tableActvities+
-			" SET "+columnsActivitiesProjectID+"=$1, "+columnsActivitiesStartedAt+"=$2, "+columnsActivitiesEndedAt+"=$3"+
-			" WHERE "+columnsActivitiesActivityID+"=$4;",
-		project.ID, activity.StartedAt, activity.EndedAt, activity.ID)
-	if err != nil {
-		return r.logger.LogAndAbstractError("database error", "Couldn't change activity: %+v", err)
-	}
-
-	rowsAffected, err := res.RowsAffected()
+		"UPDATE "+tableProjects+
+			" SET "+columnRoundingMode+"=$3, "+columnRoundingIncrement+"=$4, "+columnRoundingMinimum+"=$5"+
+			" WHERE "+columnProjectsUserID+"=$1 AND "+columnProjectsName+"=$2;",
+		userID, name, mode, increment, minimum,
+	)
 	if err != nil {
-		return r.logger.LogAndAbstractError("database error", "Couldn't change activity: %+v", err)
+		return r.logger.LogAndAbstractError("database error", "Couldn't set rounding rule of project '%s': %+v", name, err)
 	}
 
-	if rowsAffected == 0 {
-		return r.logger.LogAndAbstractError("database error", "activity '%d' not found", activity.ID)
+	if rows, _ := res.RowsAffected(); rows != 1 {
+		return fmt.Errorf("project %s not found", name)
 	}
 
-	return r.updateWorktime(userID, activity.StartedAt)
+	return nil
 }
 
-func (r *repositoryImpl) updateWorktime(userID string, day time.Time) error {
-	activities, err := r.GetActivities(userID, day)
+func (r *repositoryImpl) DeleteProject(userID, name string) error {
+	res, err := r.database.Exec(
+		"DELETE"+
+			" FROM "+tableProjects+
+			" WHERE "+columnProjectsUserID+"=$1 AND "+columnProjectsName+"=$2;",
+		userID, name)
 	if err != nil {
-		return err
+		return r.logger.LogAndAbstractError("database error", "Couldn't delete project: %+v", err)
 	}
 
-	dailyActivities := DailyActivities{
-		Activities: activities,
-	}
-	dailyActivities.CalculateWorktime()
-	dailyActivities.CalculateBreaktime()
+	if rows, _ := res.RowsAffected(); rows != 1 {
+		r.logger.Error("Couldn't find and delete project: %s", name)
 
-	_, err = r.database.Exec(
-		"INSERT INTO "+tableWorktime+
-			" ("+columnWorktimeUserID+", "+columnWorktimeDay+", "+columnWorktimeWorktime+", "+columnWorktimeBreaktime+")"+
-			" VALUES ($1, $2::date, $3, $4)"+
-			" ON CONFLICT ("+columnWorktimeUserID+", "+columnWorktimeDay+")"+
-			" DO UPDATE SET "+columnWorktimeWorktime+"=$3, "+columnWorktimeBreaktime+"=$4;",
-		userID, day, dailyActivities.Worktime, dailyActivities.Breaktime)
-	if err != nil {
-		return r.logger.LogAndAbstractError("database error", "Couldn't update worktime: %+v", err)
+		return &database.NoRowsError{
+			Message: fmt.Sprintf("project '%s' not found", name),
+		}
 	}
 
 	return nil
 }
 
-func (r *repositoryImpl) GetWorktime(userID string) ([]*Worktime, error) {
-	rows, err := r.database.Query(
-		"SELECT "+columnWorktimeDay+", "+columnWorktimeWorktime+", "+columnWorktimeBreaktime+
-			" FROM "+tableWorktime+
-			" WHERE "+columnWorktimeUserID+"=$1"+
-			" ORDER BY "+columnWorktimeDay+" DESC;",
-		userID)
-	if err != nil {
-		return nil, r.logger.LogAndAbstractError("database error", "Couldn't get worktime: %+v", err)
+// StartProject starts the project at startedAt. A running project is stopped
+// at the same time. If focus is given, the activity is started as focus
+// session with a planned duration.
+func (r *repositoryImpl) StartProject(userID, name string, startedAt time.Time, focus *Focus) error {
+	var (
+		plannedDuration sql.NullInt64
+		focusAutoStop   bool
+	)
+	if focus != nil {
+		plannedDuration = sql.NullInt64{Int64: int64(focus.PlannedDuration.Seconds()), Valid: true}
+		focusAutoStop = focus.AutoStop
 	}
-	defer rows.Close()
 
-	var worktimes []*Worktime
-	for rows.Next() {
-		var worktime = &Worktime{}
-		var day time.Time
-		if err := rows.Scan(&day, &worktime.Worktime, &worktime.Breaktime); err != nil {
-			return nil, r.logger.LogAndAbstractError("database error", "Couldn't scan worktime: %+v", err)
+	return r.inTransaction("starting project", func(tx *sql.Tx) error {
+		if err := r.lockUserWithTx(tx, userID); err != nil {
+			return err
 		}
-		worktime.UserID = userID
-		worktime.Day = day
-		worktimes = append(worktimes, worktime)
-	}
 
-	if err := rows.Err(); err != nil {
-		return nil, r.logger.LogAndAbstractError("database error", "Error iterating worktime rows: %+v", err)
-	}
+		project, err := r.getProjectWithTx(tx, userID, name)
+		if err != nil {
+			return err
+		}
 
-	return worktimes, nil
+		if project.StartedAt != nil {
+			return ErrAlreadyStarted
+		}
+
+		runningProject, err := r.getRunningProjectWithTx(tx, userID)
+		if err != nil {
+			return err
+		}
+
+		if runningProject != nil && startedAt.Before(*runningProject.StartedAt) {
+			return fmt.Errorf("startedAt must not be before the start of the running project '%s'", runningProject.Name)
+		}
+
+		// the running project needs to be stopped first, as only one activity
+		// of the user may be open at a time
+		from := startedAt
+		if runningProject != nil {
+			if _, err = r.stopProjectWithTx(tx, runningProject.UserID, runningProject.Name, startedAt, false); err != nil {
+				return err
+			}
+			from = *runningProject.StartedAt
+		}
+
+		// a backdated start must not overlap activities, which ended later
+		overlaps, err := r.lockOverlappingActivitiesWithTx(tx, userID, startedAt, nil)
+		if err != nil {
+			return err
+		}
+
+		if len(overlaps) > 0 {
+			return &OverlapError{Activities: overlaps}
+		}
+
+		if _, err := r.database.ExecWithTx(
+			tx,
+			"INSERT INTO "+tableActvities+
+				" ("+columnsActivitiesUserID+", "+columnsActivitiesProjectID+", "+columnsActivitiesStartedAt+", "+columnsActivitiesPlannedDuration+", "+columnsActivitiesFocusAutoStop+")"+
+				" VALUES ($1, $2, $3, $4, $5);",
+			userID, project.ID, startedAt, plannedDuration, focusAutoStop,
+		); err != nil {
+			switch {
+			case errors.As(err, &database.DuplicateError{}):
+				return errors.New("another project is already running")
+			default:
+				return r.logger.LogAndAbstractError("database error", "Couldn't start project '%s': %+v", name, err)
+			}
+		}
+
+		if _, err := r.database.ExecWithTx(
+			tx,
+			"UPDATE "+tableProjects+
+				" SET "+columnProjectsStartedAt+"=$2"+
+				" WHERE "+columnProjectsProjectID+"=$1;",
+			project.ID, startedAt,
+		); err != nil {
+			return r.logger.LogAndAbstractError("database error", "Couldn't start project '%s': %+v", name, err)
+		}
+
+		// starting any project resumes or abandons a paused project
+		if _, err := r.database.ExecWithTx(
+			tx,
+			"UPDATE "+tableProjects+
+				" SET "+columnProjectsPausedAt+"=NULL"+
+				" WHERE "+columnProjectsUserID+"=$1 AND "+columnProjectsPausedAt+" IS NOT NULL;",
+			userID,
+		); err != nil {
+			return r.logger.LogAndAbstractError("database error", "Couldn't start project '%s': %+v", name, err)
+		}
+
+		// the worktime of all days the stopped project ran on needs to be updated
+		return r.updateWorktime(tx, userID, from, time.Now())
+	})
+}
+
+// StopProject stops the running project at endedAt and returns the stopped
+// activity. If discardIdleAfter is set and no heartbeat was received for longer
+// than it before endedAt, the activity is trimmed to its last heartbeat.
+func (r *repositoryImpl) StopProject(userID, name string, endedAt time.Time, discardIdleAfter *time.Duration) (*Activity, error) {
+	var activity *Activity
+	err := r.inTransaction("stopping project", func(tx *sql.Tx) error {
+		var err error
+		activity, err = r.stopProjectWithTx(tx, userID, name, endedAt, false)
+		if err != nil {
+			return err
+		}
+
+		if discardIdleAfter != nil && activity.LastSeenAt != nil && activity.EndedAt.Sub(*activity.LastSeenAt) > *discardIdleAfter {
+			if _, err := r.discardIdleWithTx(tx, userID, activity.ID); err != nil {
+				return err
+			}
+			activity.EndedAt = activity.LastSeenAt
+		}
+
+		return r.updateWorktime(tx, userID, activity.StartedAt, time.Now())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return activity, nil
+}
+
+// PauseProject stops the running project at pausedAt, but keeps it as the
+// current project of the user, so that it can be resumed. The time until the
+// project is resumed counts as break.
+func (r *repositoryImpl) PauseProject(userID, name string, pausedAt time.Time) (*Activity, error) {
+	var activity *Activity
+	err := r.inTransaction("pausing project", func(tx *sql.Tx) error {
+		var err error
+		activity, err = r.stopProjectWithTx(tx, userID, name, pausedAt, false)
+		if err != nil {
+			return err
+		}
+
+		if _, err := r.database.ExecWithTx(
+			tx,
+			"UPDATE "+tableActvities+
+				" SET "+columnsActivitiesPaused+"=TRUE"+
+				" WHERE "+columnsActivitiesActivityID+"=$1;",
+			activity.ID,
+		); err != nil {
+			return r.logger.LogAndAbstractError("database error", "Couldn't pause project '%s': %+v", name, err)
+		}
+		activity.Paused = true
+
+		if _, err := r.database.ExecWithTx(
+			tx,
+			"UPDATE "+tableProjects+
+				" SET "+columnProjectsPausedAt+"=$3"+
+				" WHERE "+columnProjectsUserID+"=$1 AND "+columnProjectsName+"=$2;",
+			userID, name, pausedAt,
+		); err != nil {
+			return r.logger.LogAndAbstractError("database error", "Couldn't pause project '%s': %+v", name, err)
+		}
+
+		return r.updateWorktime(tx, userID, activity.StartedAt, time.Now())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return activity, nil
+}
+
+// EndPause ends the pause of the project without resuming it.
+func (r *repositoryImpl) EndPause(userID, name string) error {
+	res, err := r.database.Exec(
+		"UPDATE "+tableProjects+
+			" SET "+columnProjectsPausedAt+"=NULL"+
+			" WHERE "+columnProjectsUserID+"=$1 AND "+columnProjectsName+"=$2 AND "+columnProjectsPausedAt+" IS NOT NULL;",
+		userID, name)
+	if err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't end pause of project '%s': %+v", name, err)
+	}
+
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return fmt.Errorf("project not paused")
+	}
+
+	return nil
+}
+
+// AutoStopProject stops the running project at endedAt like StopProject, but
+// flags the activity as auto-stopped, so that the user can review it.
+func (r *repositoryImpl) AutoStopProject(userID, name string, endedAt time.Time) error {
+	return r.inTransaction("auto-stopping project", func(tx *sql.Tx) error {
+		activity, err := r.stopProjectWithTx(tx, userID, name, endedAt, true)
+		if err != nil {
+			return err
+		}
+
+		return r.updateWorktime(tx, userID, activity.StartedAt, time.Now())
+	})
+}
+
+// StopFocusSession stops the running project at the planned end of its focus
+// session. As the stop is planned, the activity isn't flagged as auto-stopped.
+func (r *repositoryImpl) StopFocusSession(userID, name string, plannedEndAt time.Time) error {
+	return r.inTransaction("stopping focus session", func(tx *sql.Tx) error {
+		activity, err := r.stopProjectWithTx(tx, userID, name, plannedEndAt, false)
+		if err != nil {
+			return err
+		}
+
+		return r.updateWorktime(tx, userID, activity.StartedAt, time.Now())
+	})
+}
+
+// GetElapsedFocusSessions returns the running focus sessions of all users,
+// which should be stopped automatically and whose planned end passed.
+func (r *repositoryImpl) GetElapsedFocusSessions() ([]*ElapsedFocusSession, error) {
+	rows, err := r.database.Query(
+		"SELECT p." + columnProjectsUserID + ", p." + columnProjectsName + ", a." + columnsActivitiesStartedAt + ", a." + columnsActivitiesPlannedDuration +
+			" FROM " + tableActvities + " a" +
+			" JOIN " + tableProjects + " p ON a." + columnsActivitiesProjectID + "=p." + columnProjectsProjectID +
+			" WHERE a." + columnsActivitiesEndedAt + " IS NULL AND a." + columnsActivitiesFocusAutoStop +
+			" AND a." + columnsActivitiesStartedAt + " + a." + columnsActivitiesPlannedDuration + " * INTERVAL '1 second' <= NOW();",
+	)
+	if err != nil {
+		return nil, r.logger.LogAndAbstractError("database error", "Error getting elapsed focus sessions: %+v", err)
+	}
+	defer rows.Close()
+
+	var sessions []*ElapsedFocusSession
+	for rows.Next() {
+		var (
+			session         = &ElapsedFocusSession{}
+			startedAt       time.Time
+			plannedDuration int64
+		)
+		if err := rows.Scan(&session.UserID, &session.ProjectName, &startedAt, &plannedDuration); err != nil {
+			return nil, r.logger.LogAndAbstractError("database error", "Error scanning focus session: %+v", err)
+		}
+		session.PlannedEndAt = startedAt.Add(time.Duration(plannedDuration) * time.Second)
+
+		sessions = append(sessions, session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, r.logger.LogAndAbstractError("database error", "Error iterating focus session rows: %+v", err)
+	}
+
+	return sessions, nil
+}
+
+// stopProjectWithTx stops the running project at endedAt and returns the stopped
+// activity. Updating the worktime is left to the caller.
+func (r *repositoryImpl) stopProjectWithTx(tx *sql.Tx, userID, name string, endedAt time.Time, autoStopped bool) (*Activity, error) {
+	if err := r.lockUserWithTx(tx, userID); err != nil {
+		return nil, err
+	}
+
+	project, err := r.getProjectWithTx(tx, userID, name)
+	if err != nil {
+		return nil, r.logger.LogAndAbstractError("database error", "Failed to fetch project '%s': %+v", name, err)
+	}
+
+	if project.StartedAt == nil {
+		return nil, ErrNotRunning
+	}
+
+	if endedAt.Before(*project.StartedAt) {
+		return nil, fmt.Errorf("endedAt must not be before the start of the project at %s", project.StartedAt.Format(time.RFC3339))
+	}
+
+	location, err := r.GetLocation(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	endedAtExpression := "$2::timestamptz"
+	if autoStopped {
+		// without a user present, the activity is only known to have lasted
+		// until the last heartbeat
+		endedAtExpression = "LEAST($2::timestamptz, COALESCE(" + columnsActivitiesLastSeenAt + ", $2::timestamptz))"
+	}
+
+	activity := &DbActivity{
+		ProjectName: project.Name,
+		AutoStopped: autoStopped,
+	}
+	err = r.database.QueryRowWithTx(
+		tx,
+		"UPDATE "+tableActvities+
+			" SET "+columnsActivitiesEndedAt+"="+endedAtExpression+", "+columnsActivitiesAutoStopped+"=$3"+
+			" WHERE "+columnsActivitiesProjectID+"=$1 AND "+columnsActivitiesEndedAt+" IS NULL"+
+			" RETURNING "+columnsActivitiesActivityID+", "+columnsActivitiesStartedAt+", "+columnsActivitiesEndedAt+", "+columnsActivitiesLastSeenAt+", "+columnsActivitiesPlannedDuration+", "+columnsActivitiesCreatedAt+", "+columnsActivitiesUpdatedAt+";",
+		[]any{project.ID, endedAt, autoStopped},
+		&activity.ID,
+		&activity.StartedAt,
+		&activity.EndedAt,
+		&activity.LastSeenAt,
+		&activity.PlannedDuration,
+		&activity.CreatedAt,
+		&activity.UpdatedAt,
+	)
+	if err != nil {
+		return nil, r.logger.LogAndAbstractError("database error", "Couldn't stop project '%s': %+v", name, err)
+	}
+
+	_, err = r.database.ExecWithTx(
+		tx,
+		"UPDATE "+tableProjects+
+			" SET "+columnProjectsStartedAt+"=NULL"+
+			" WHERE "+columnProjectsProjectID+"=$1;",
+		project.ID,
+	)
+	if err != nil {
+		return nil, r.logger.LogAndAbstractError("database error", "Couldn't stop project '%s': %+v", name, err)
+	}
+
+	return activity.ToDomain(location), nil
+}
+
+// Heartbeat records that the user is still active on the running project.
+func (r *repositoryImpl) Heartbeat(userID, name string) error {
+	res, err := r.database.Exec(
+		"UPDATE "+tableActvities+" a"+
+			" SET "+columnsActivitiesLastSeenAt+"=NOW()"+
+			" FROM "+tableProjects+" p"+
+			" WHERE a."+columnsActivitiesProjectID+"=p."+columnProjectsProjectID+
+			" AND p."+columnProjectsUserID+"=$1 AND p."+columnProjectsName+"=$2 AND a."+columnsActivitiesEndedAt+" IS NULL;",
+		userID, name)
+	if err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't record heartbeat: %+v", err)
+	}
+
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return ErrNotRunning
+	}
+
+	return nil
+}
+
+// GetRunningProjects returns the running projects of all users. Their
+// activities are not read.
+func (r *repositoryImpl) GetRunningProjects() ([]*Project, error) {
+	rows, err := r.database.Query(
+		"SELECT " + columnProjectsProjectID + ", " + columnProjectsUserID + ", " + columnProjectsName + ", " + columnProjectsStartedAt + ", " + columnProjectsPausedAt + ", " + columnProjectsCreatedAt + ", " + columnProjectsUpdatedAt +
+			" FROM " + tableProjects +
+			" WHERE " + columnProjectsStartedAt + " IS NOT NULL;",
+	)
+	if err != nil {
+		return nil, r.logger.LogAndAbstractError("database error", "Error getting running projects: %+v", err)
+	}
+	defer rows.Close()
+
+	var dbProjects []*DbProject
+	for rows.Next() {
+		project := &DbProject{}
+		if err := rows.Scan(
+			&project.ID,
+			&project.UserID,
+			&project.Name,
+			&project.StartedAt,
+			&project.PausedAt,
+			&project.CreatedAt,
+			&project.UpdatedAt,
+		); err != nil {
+			return nil, r.logger.LogAndAbstractError("database error", "Error scanning project: %+v", err)
+		}
+
+		dbProjects = append(dbProjects, project)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, r.logger.LogAndAbstractError("database error", "Error iterating project rows: %+v", err)
+	}
+	rows.Close()
+
+	projects := make([]*Project, 0, len(dbProjects))
+	for _, project := range dbProjects {
+		location, err := r.GetLocation(project.UserID)
+		if err != nil {
+			return nil, err
+		}
+
+		projects = append(projects, project.ToDomain(location))
+	}
+
+	return projects, nil
+}
+
+// GetMaxTimerDuration returns the duration, after which running projects of
+// the user are stopped automatically, or nil, if the user didn't set one.
+func (r *repositoryImpl) GetMaxTimerDuration(userID string) (*time.Duration, error) {
+	var seconds sql.NullInt64
+	if err := r.database.QueryRow(
+		"SELECT "+columnUsersMaxTimerDuration+
+			" FROM "+tableUsers+
+			" WHERE "+columnUsersUserID+"=$1;",
+		[]any{userID},
+		&seconds,
+	); err != nil {
+		switch {
+		case errors.As(err, &database.NoRowsError{}):
+			return nil, nil
+		default:
+			return nil, r.logger.LogAndAbstractError("database error", "Error reading max timer duration: %+v", err)
+		}
+	}
+
+	if !seconds.Valid {
+		return nil, nil
+	}
+
+	maxTimerDuration := time.Duration(seconds.Int64) * time.Second
+
+	return &maxTimerDuration, nil
+}
+
+// GetActivities returns all activities touching the calendar date of day,
+// where the boundaries of the day are determined by the time zone of the user.
+// Activities spanning midnight are returned for every day they touch.
+func (r *repositoryImpl) GetActivities(userID string, day time.Time) (Activities, error) {
+	return r.getActivitiesWithTx(nil, userID, day)
+}
+
+// getActivitiesWithTx reads the activities like GetActivities, but within the
+// transaction, if it is given.
+func (r *repositoryImpl) getActivitiesWithTx(tx *sql.Tx, userID string, day time.Time) (Activities, error) {
+	location, err := r.GetLocation(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	dayStart, dayEnd := DayBounds(day, location)
+
+	rows, err := r.query(
+		tx,
+		"SELECT"+
+			" a."+columnsActivitiesActivityID+", a."+columnsActivitiesStartedAt+", a."+columnsActivitiesEndedAt+", a."+columnsActivitiesAutoStopped+", a."+columnsActivitiesLastSeenAt+", a."+columnsActivitiesPaused+", a."+columnsActivitiesPlannedDuration+", a."+columnsActivitiesCreatedAt+", a."+columnsActivitiesUpdatedAt+
+			", p."+columnProjectsName+", "+selectEffectiveRounding+
+			" FROM "+tableActvities+" a"+
+			" LEFT JOIN "+tableProjects+" p ON a."+columnsActivitiesProjectID+"=p."+columnProjectsProjectID+
+			" LEFT JOIN "+tableUsers+" u ON p."+columnProjectsUserID+"=u."+columnUsersUserID+
+			" WHERE a."+columnsActivitiesStartedAt+"<$2"+
+			" AND (a."+columnsActivitiesStartedAt+">=$1 OR a."+columnsActivitiesEndedAt+" IS NULL OR a."+columnsActivitiesEndedAt+">$1)"+
+			" AND p."+columnProjectsUserID+"=$3"+
+			" ORDER BY a."+columnsActivitiesStartedAt+" ASC;",
+		dayStart,
+		dayEnd,
+		userID,
+	)
+	if err != nil {
+		switch {
+		case errors.As(err, &database.NoRowsError{}):
+			return Activities{}, nil
+		default:
+			return nil, r.logger.LogAndAbstractError("database error", "Error getting activities: %+v", err)
+		}
+	}
+	defer rows.Close()
+
+	activitySlice := Activities{}
+	for rows.Next() {
+		activity := &DbActivity{}
+		if err := rows.Scan(
+			&activity.ID,
+			&activity.StartedAt,
+			&activity.EndedAt,
+			&activity.AutoStopped,
+			&activity.LastSeenAt,
+			&activity.Paused,
+			&activity.PlannedDuration,
+			&activity.CreatedAt,
+			&activity.UpdatedAt,
+			&activity.ProjectName,
+			&activity.RoundingMode,
+			&activity.RoundingIncrement,
+			&activity.RoundingMinimum,
+		); err != nil {
+			return nil, r.logger.LogAndAbstractError("database error", "Error scanning activities: %+v", err)
+		}
+
+		activitySlice = append(activitySlice, activity.ToDomain(location))
+	}
+
+	slices.SortFunc(activitySlice, func(a, b *Activity) int {
+		return a.StartedAt.Compare(b.StartedAt)
+	})
+
+	return activitySlice, nil
+}
+
+// ChangeActivity changes project, start and end of the activity. If it would
+// overlap other activities, an OverlapError is returned, unless trimNeighbours
+// is set and the overlapping activities can be shortened to make room.
+func (r *repositoryImpl) ChangeActivity(userID string, activity Activity, trimNeighbours bool) error {
+	project, err := r.GetProject(userID, activity.ProjectName)
+	if err != nil {
+		return err
+	}
+
+	return r.inTransaction("changing activity", func(tx *sql.Tx) error {
+		if err := r.lockUserWithTx(tx, userID); err != nil {
+			return err
+		}
+
+		var (
+			previousStartedAt time.Time
+			previousEndedAt   sql.NullTime
+		)
+		if err := r.database.QueryRowWithTx(
+			tx,
+			"SELECT a."+columnsActivitiesStartedAt+", a."+columnsActivitiesEndedAt+
+				" FROM "+tableActvities+" a"+
+				" JOIN "+tableProjects+" p ON a."+columnsActivitiesProjectID+"=p."+columnProjectsProjectID+
+				" WHERE a."+columnsActivitiesActivityID+"=$1 AND p."+columnProjectsUserID+"=$2"+
+				" FOR UPDATE OF a;",
+			[]any{activity.ID, userID},
+			&previousStartedAt,
+			&previousEndedAt,
+		); err != nil {
+			switch {
+			case errors.As(err, &database.NoRowsError{}):
+				return fmt.Errorf("activity '%d' not found", activity.ID)
+			default:
+				return r.logger.LogAndAbstractError("database error", "Couldn't read activity: %+v", err)
+			}
+		}
+
+		overlaps, err := r.lockOverlappingActivitiesWithTx(tx, userID, activity.StartedAt, activity.EndedAt, activity.ID)
+		if err != nil {
+			return err
+		}
+
+		if len(overlaps) > 0 {
+			if !trimNeighbours {
+				return &OverlapError{Activities: overlaps}
+			}
+
+			if err := r.trimNeighboursWithTx(tx, userID, activity, overlaps); err != nil {
+				return err
+			}
+		}
+
+		if _, err := r.database.ExecWithTx(
+			tx,
+			"UPDATE "+tableActvities+
+				" SET "+columnsActivitiesProjectID+"=$1, "+columnsActivitiesStartedAt+"=$2, "+columnsActivitiesEndedAt+"=$3, "+columnsActivitiesAutoStopped+"=FALSE"+
+				" WHERE "+columnsActivitiesActivityID+"=$4;",
+			project.ID, activity.StartedAt, activity.EndedAt, activity.ID,
+		); err != nil {
+			switch {
+			case errors.As(err, &database.DuplicateError{}):
+				return errors.New("another activity is already running")
+			default:
+				return r.logger.LogAndAbstractError("database error", "Couldn't change activity: %+v", err)
+			}
+		}
+
+		// the days the activity moved away from need to be updated as well
+		previousEnd := time.Now()
+		if previousEndedAt.Valid {
+			previousEnd = previousEndedAt.Time
+		}
+		if err := r.updateWorktime(tx, userID, previousStartedAt, previousEnd); err != nil {
+			return err
+		}
+
+		endedAt := time.Now()
+		if activity.EndedAt != nil {
+			endedAt = *activity.EndedAt
+		}
+
+		return r.updateWorktime(tx, userID, activity.StartedAt, endedAt)
+	})
+}
+
+// lockOverlappingActivitiesWithTx reads all activities of the user except the
+// excluded ones, which overlap the time from startedAt to endedAt, and locks
+// them until the end of the transaction. A nil endedAt is still running.
+func (r *repositoryImpl) lockOverlappingActivitiesWithTx(tx *sql.Tx, userID string, startedAt time.Time, endedAt *time.Time, excludedIDs ...int) (Activities, error) {
+	location, err := r.GetLocation(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// an empty array instead of NULL, so that no activity is excluded
+	ids := make([]int64, 0, len(excludedIDs))
+	for _, id := range excludedIDs {
+		ids = append(ids, int64(id))
+	}
+
+	rows, err := r.query(
+		tx,
+		"SELECT a."+columnsActivitiesActivityID+", a."+columnsActivitiesStartedAt+", a."+columnsActivitiesEndedAt+", p."+columnProjectsName+
+			" FROM "+tableActvities+" a"+
+			" JOIN "+tableProjects+" p ON a."+columnsActivitiesProjectID+"=p."+columnProjectsProjectID+
+			" WHERE p."+columnProjectsUserID+"=$1 AND a."+columnsActivitiesActivityID+"<>ALL($2)"+
+			" AND ($4::timestamptz IS NULL OR a."+columnsActivitiesStartedAt+"<$4)"+
+			" AND (a."+columnsActivitiesEndedAt+" IS NULL OR a."+columnsActivitiesEndedAt+">$3)"+
+			" ORDER BY a."+columnsActivitiesStartedAt+" ASC"+
+			" FOR UPDATE OF a;",
+		userID, pq.Array(ids), startedAt, endedAt,
+	)
+	if err != nil {
+		return nil, r.logger.LogAndAbstractError("database error", "Error getting overlapping activities: %+v", err)
+	}
+	defer rows.Close()
+
+	overlaps := Activities{}
+	for rows.Next() {
+		overlap := &DbActivity{}
+		if err := rows.Scan(
+			&overlap.ID,
+			&overlap.StartedAt,
+			&overlap.EndedAt,
+			&overlap.ProjectName,
+		); err != nil {
+			return nil, r.logger.LogAndAbstractError("database error", "Error scanning overlapping activity: %+v", err)
+		}
+
+		overlaps = append(overlaps, overlap.ToDomain(location))
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, r.logger.LogAndAbstractError("database error", "Error iterating overlapping activities: %+v", err)
+	}
+
+	return overlaps, nil
+}
+
+// trimNeighboursWithTx shortens the overlapping activities, so that they end
+// before the activity starts or start after it ended. Activities, which would
+// need to be removed or cut in two, can't be trimmed and result in an
+// OverlapError.
+func (r *repositoryImpl) trimNeighboursWithTx(tx *sql.Tx, userID string, activity Activity, overlaps Activities) error {
+	var untrimmable Activities
+	for _, overlap := range overlaps {
+		switch {
+		case overlap.StartedAt.Before(activity.StartedAt) && overlap.EndedAt != nil &&
+			(activity.EndedAt == nil || !overlap.EndedAt.After(*activity.EndedAt)):
+			if _, err := r.database.ExecWithTx(
+				tx,
+				"UPDATE "+tableActvities+
+					" SET "+columnsActivitiesEndedAt+"=$2"+
+					" WHERE "+columnsActivitiesActivityID+"=$1;",
+				overlap.ID, activity.StartedAt,
+			); err != nil {
+				return r.logger.LogAndAbstractError("database error", "Couldn't trim activity: %+v", err)
+			}
+		case !overlap.StartedAt.Before(activity.StartedAt) && activity.EndedAt != nil &&
+			(overlap.EndedAt == nil || overlap.EndedAt.After(*activity.EndedAt)):
+			if _, err := r.database.ExecWithTx(
+				tx,
+				"UPDATE "+tableActvities+
+					" SET "+columnsActivitiesStartedAt+"=$2"+
+					" WHERE "+columnsActivitiesActivityID+"=$1;",
+				overlap.ID, *activity.EndedAt,
+			); err != nil {
+				return r.logger.LogAndAbstractError("database error", "Couldn't trim activity: %+v", err)
+			}
+
+			// a running project is running since the new start of its activity
+			if overlap.EndedAt == nil {
+				if _, err := r.database.ExecWithTx(
+					tx,
+					"UPDATE "+tableProjects+
+						" SET "+columnProjectsStartedAt+"=$3"+
+						" WHERE "+columnProjectsUserID+"=$1 AND "+columnProjectsName+"=$2 AND "+columnProjectsStartedAt+" IS NOT NULL;",
+					userID, overlap.ProjectName, *activity.EndedAt,
+				); err != nil {
+					return r.logger.LogAndAbstractError("database error", "Couldn't trim activity: %+v", err)
+				}
+			}
+		default:
+			untrimmable = append(untrimmable, overlap)
+			continue
+		}
+
+		overlapEnd := time.Now()
+		if overlap.EndedAt != nil {
+			overlapEnd = *overlap.EndedAt
+		}
+		if err := r.updateWorktime(tx, userID, overlap.StartedAt, overlapEnd); err != nil {
+			return err
+		}
+	}
+
+	if len(untrimmable) > 0 {
+		return &OverlapError{Activities: untrimmable}
+	}
+
+	return nil
+}
+
+// DiscardIdle trims the activity to its last heartbeat, so that the time the
+// user was idle before stopping isn't counted as work.
+func (r *repositoryImpl) DiscardIdle(userID string, activityID int) error {
+	return r.inTransaction("discarding idle time", func(tx *sql.Tx) error {
+		activity, err := r.discardIdleWithTx(tx, userID, activityID)
+		if err != nil {
+			return err
+		}
+
+		return r.updateWorktime(tx, userID, activity.startedAt, activity.endedAt.Time)
+	})
+}
+
+// discardIdleWithTx trims the activity to its last heartbeat and returns it
+// as it was before. Updating the worktime is left to the caller.
+func (r *repositoryImpl) discardIdleWithTx(tx *sql.Tx, userID string, activityID int) (*lockedActivity, error) {
+	activity, err := r.lockActivityWithTx(
+		tx,
+		"a."+columnsActivitiesActivityID+"=$2",
+		[]any{userID, activityID},
+	)
+	if err != nil {
+		switch {
+		case errors.As(err, &database.NoRowsError{}):
+			return nil, fmt.Errorf("activity '%d' not found", activityID)
+		default:
+			return nil, r.logger.LogAndAbstractError("database error", "Couldn't read activity: %+v", err)
+		}
+	}
+
+	if !activity.endedAt.Valid {
+		return nil, errors.New("activity is still running")
+	}
+
+	if !activity.lastSeenAt.Valid || !activity.lastSeenAt.Time.Before(activity.endedAt.Time) {
+		return nil, errors.New("activity has no idle time")
+	}
+
+	if _, err := r.database.ExecWithTx(
+		tx,
+		"UPDATE "+tableActvities+
+			" SET "+columnsActivitiesEndedAt+"="+columnsActivitiesLastSeenAt+
+			" WHERE "+columnsActivitiesActivityID+"=$1;",
+		activityID,
+	); err != nil {
+		return nil, r.logger.LogAndAbstractError("database error", "Couldn't discard idle time: %+v", err)
+	}
+
+	return activity, nil
+}
+
+// lockedActivity is an activity read for update within a transaction.
+type lockedActivity struct {
+	id          int
+	projectID   int
+	startedAt   time.Time
+	endedAt     sql.NullTime
+	lastSeenAt  sql.NullTime
+	autoStopped bool
+	paused      bool
+	// plannedDuration and focusAutoStop are only set for focus sessions
+	plannedDuration sql.NullInt64
+	focusAutoStop   bool
+}
+
+// end returns the end of the activity or now, if it is still running.
+func (a *lockedActivity) end() time.Time {
+	if a.endedAt.Valid {
+		return a.endedAt.Time
+	}
+
+	return time.Now()
+}
+
+// endPointer returns the end of the activity or nil, if it is still running.
+func (a *lockedActivity) endPointer() *time.Time {
+	if a.endedAt.Valid {
+		return &a.endedAt.Time
+	}
+
+	return nil
+}
+
+// lockActivityWithTx reads the first activity of the user matching the where
+// clause, ordered by start, and locks it until the end of the transaction. The
+// activity is referred to as a, the project as p and the user always as $1.
+func (r *repositoryImpl) lockActivityWithTx(tx *sql.Tx, whereClause string, args []any) (*lockedActivity, error) {
+	activity := &lockedActivity{}
+	if err := r.database.QueryRowWithTx(
+		tx,
+		"SELECT a."+columnsActivitiesActivityID+", a."+columnsActivitiesProjectID+", a."+columnsActivitiesStartedAt+", a."+columnsActivitiesEndedAt+", a."+columnsActivitiesLastSeenAt+", a."+columnsActivitiesAutoStopped+", a."+columnsActivitiesPaused+", a."+columnsActivitiesPlannedDuration+", a."+columnsActivitiesFocusAutoStop+
+			" FROM "+tableActvities+" a"+
+			" JOIN "+tableProjects+" p ON a."+columnsActivitiesProjectID+"=p."+columnProjectsProjectID+
+			" WHERE p."+columnProjectsUserID+"=$1 AND "+whereClause+
+			" ORDER BY a."+columnsActivitiesStartedAt+" ASC"+
+			" LIMIT 1"+
+			" FOR UPDATE OF a;",
+		args,
+		&activity.id,
+		&activity.projectID,
+		&activity.startedAt,
+		&activity.endedAt,
+		&activity.lastSeenAt,
+		&activity.autoStopped,
+		&activity.paused,
+		&activity.plannedDuration,
+		&activity.focusAutoStop,
+	); err != nil {
+		return nil, err
+	}
+
+	return activity, nil
+}
+
+// SplitActivity cuts the activity at splitAt into two and returns the id of
+// the second part. If projectName is set, the second part is reassigned to that
+// project. If the activity is still running, the second part keeps running. A
+// focus session, which didn't reach its planned end before splitAt, continues
+// in the second part with the same planned end.
+func (r *repositoryImpl) SplitActivity(userID string, activityID int, splitAt time.Time, projectName string) (int, error) {
+	var targetProjectID int
+	if projectName != "" {
+		project, err := r.GetProject(userID, projectName)
+		if err != nil {
+			return 0, err
+		}
+		targetProjectID = project.ID
+	}
+
+	var secondID int
+	err := r.inTransaction("splitting activity", func(tx *sql.Tx) error {
+		if err := r.lockUserWithTx(tx, userID); err != nil {
+			return err
+		}
+
+		activity, err := r.lockActivityWithTx(
+			tx,
+			"a."+columnsActivitiesActivityID+"=$2",
+			[]any{userID, activityID},
+		)
+		if err != nil {
+			switch {
+			case errors.As(err, &database.NoRowsError{}):
+				return fmt.Errorf("activity '%d' not found", activityID)
+			default:
+				return r.logger.LogAndAbstractError("database error", "Couldn't read activity: %+v", err)
+			}
+		}
+
+		if !splitAt.After(activity.startedAt) || !splitAt.Before(activity.end()) {
+			return errors.New("splitAt must be within the activity")
+		}
+
+		overlaps, err := r.lockOverlappingActivitiesWithTx(tx, userID, activity.startedAt, activity.endPointer(), activity.id)
+		if err != nil {
+			return err
+		}
+
+		if len(overlaps) > 0 {
+			return &OverlapError{Activities: overlaps}
+		}
+
+		if targetProjectID == 0 {
+			targetProjectID = activity.projectID
+		}
+
+		var (
+			secondPlannedDuration sql.NullInt64
+			secondFocusAutoStop   bool
+		)
+		if activity.plannedDuration.Valid {
+			plannedEndAt := activity.startedAt.Add(time.Duration(activity.plannedDuration.Int64) * time.Second)
+			if plannedEndAt.After(splitAt) {
+				secondPlannedDuration = sql.NullInt64{Int64: int64(plannedEndAt.Sub(splitAt).Seconds()), Valid: true}
+				secondFocusAutoStop = activity.focusAutoStop
+			}
+		}
+
+		// the first part is ended before the second part is inserted, as only
+		// one activity of the user may be open at a time. A focus session
+		// continuing in the second part is removed from the first one.
+		if _, err := r.database.ExecWithTx(
+			tx,
+			"UPDATE "+tableActvities+
+				" SET "+columnsActivitiesEndedAt+"=$2, "+columnsActivitiesAutoStopped+"=FALSE, "+columnsActivitiesPaused+"=FALSE"+
+				", "+columnsActivitiesLastSeenAt+"=CASE WHEN "+columnsActivitiesLastSeenAt+"<=$2 THEN "+columnsActivitiesLastSeenAt+" END"+
+				", "+columnsActivitiesPlannedDuration+"=CASE WHEN $3 THEN NULL ELSE "+columnsActivitiesPlannedDuration+" END"+
+				", "+columnsActivitiesFocusAutoStop+"="+columnsActivitiesFocusAutoStop+" AND NOT $3"+
+				" WHERE "+columnsActivitiesActivityID+"=$1;",
+			activityID, splitAt, secondPlannedDuration.Valid,
+		); err != nil {
+			return r.logger.LogAndAbstractError("database error", "Couldn't split activity: %+v", err)
+		}
+
+		// the second part takes over how the activity ended
+		var secondLastSeenAt sql.NullTime
+		if activity.lastSeenAt.Valid && activity.lastSeenAt.Time.After(splitAt) {
+			secondLastSeenAt = activity.lastSeenAt
+		}
+		if err := r.database.QueryRowWithTx(
+			tx,
+			"INSERT INTO "+tableActvities+
+				" ("+columnsActivitiesUserID+", "+columnsActivitiesProjectID+", "+columnsActivitiesStartedAt+", "+columnsActivitiesEndedAt+", "+columnsActivitiesAutoStopped+", "+columnsActivitiesLastSeenAt+", "+columnsActivitiesPaused+", "+columnsActivitiesPlannedDuration+", "+columnsActivitiesFocusAutoStop+")"+
+				" VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)"+
+				" RETURNING "+columnsActivitiesActivityID+";",
+			[]any{userID, targetProjectID, splitAt, activity.endedAt, activity.autoStopped, secondLastSeenAt, activity.paused, secondPlannedDuration, secondFocusAutoStop},
+			&secondID,
+		); err != nil {
+			return r.logger.LogAndAbstractError("database error", "Couldn't split activity: %+v", err)
+		}
+
+		if !activity.endedAt.Valid {
+			if err := r.moveRunningStartWithTx(tx, activity.projectID, targetProjectID, splitAt); err != nil {
+				return err
+			}
+		}
+
+		return r.updateWorktime(tx, userID, activity.startedAt, activity.end())
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return secondID, nil
+}
+
+// MergeActivity joins the activity with the activity directly following it,
+// which must belong to the same project. The gap between both is counted as
+// part of the merged activity.
+func (r *repositoryImpl) MergeActivity(userID string, activityID int) error {
+	return r.inTransaction("merging activities", func(tx *sql.Tx) error {
+		if err := r.lockUserWithTx(tx, userID); err != nil {
+			return err
+		}
+
+		first, err := r.lockActivityWithTx(
+			tx,
+			"a."+columnsActivitiesActivityID+"=$2",
+			[]any{userID, activityID},
+		)
+		if err != nil {
+			switch {
+			case errors.As(err, &database.NoRowsError{}):
+				return fmt.Errorf("activity '%d' not found", activityID)
+			default:
+				return r.logger.LogAndAbstractError("database error", "Couldn't read activity: %+v", err)
+			}
+		}
+
+		if !first.endedAt.Valid {
+			return errors.New("activity is still running")
+		}
+
+		second, err := r.lockActivityWithTx(
+			tx,
+			"a."+columnsActivitiesActivityID+"<>$2 AND a."+columnsActivitiesStartedAt+">=$3",
+			[]any{userID, activityID, first.startedAt},
+		)
+		if err != nil {
+			switch {
+			case errors.As(err, &database.NoRowsError{}):
+				return fmt.Errorf("activity '%d' has no following activity", activityID)
+			default:
+				return r.logger.LogAndAbstractError("database error", "Couldn't read activity: %+v", err)
+			}
+		}
+
+		if second.projectID != first.projectID {
+			return errors.New("the following activity belongs to another project")
+		}
+
+		// the merged activity also covers the gap between both
+		overlaps, err := r.lockOverlappingActivitiesWithTx(tx, userID, first.startedAt, second.endPointer(), first.id, second.id)
+		if err != nil {
+			return err
+		}
+
+		if len(overlaps) > 0 {
+			return &OverlapError{Activities: overlaps}
+		}
+
+		// the second activity is deleted first, as only one activity of the
+		// user may be open at a time
+		if _, err := r.database.ExecWithTx(
+			tx,
+			"DELETE FROM "+tableActvities+
+				" WHERE "+columnsActivitiesActivityID+"=$1;",
+			second.id,
+		); err != nil {
+			return r.logger.LogAndAbstractError("database error", "Couldn't merge activities: %+v", err)
+		}
+
+		if _, err := r.database.ExecWithTx(
+			tx,
+			"UPDATE "+tableActvities+
+				" SET "+columnsActivitiesEndedAt+"=$2, "+columnsActivitiesAutoStopped+"=$3, "+columnsActivitiesLastSeenAt+"=$4, "+columnsActivitiesPaused+"=$5"+
+				" WHERE "+columnsActivitiesActivityID+"=$1;",
+			first.id, second.endedAt, second.autoStopped, second.lastSeenAt, second.paused,
+		); err != nil {
+			return r.logger.LogAndAbstractError("database error", "Couldn't merge activities: %+v", err)
+		}
+
+		if !second.endedAt.Valid {
+			if err := r.moveRunningStartWithTx(tx, first.projectID, first.projectID, first.startedAt); err != nil {
+				return err
+			}
+		}
+
+		return r.updateWorktime(tx, userID, first.startedAt, second.end())
+	})
+}
+
+// moveRunningStartWithTx marks the project to as running since startedAt
+// instead of the project from, after the running activity was changed.
+func (r *repositoryImpl) moveRunningStartWithTx(tx *sql.Tx, from, to int, startedAt time.Time) error {
+	if _, err := r.database.ExecWithTx(
+		tx,
+		"UPDATE "+tableProjects+
+			" SET "+columnProjectsStartedAt+"=NULL"+
+			" WHERE "+columnProjectsProjectID+"=$1;",
+		from,
+	); err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't update running project: %+v", err)
+	}
+
+	if _, err := r.database.ExecWithTx(
+		tx,
+		"UPDATE "+tableProjects+
+			" SET "+columnProjectsStartedAt+"=$2"+
+			" WHERE "+columnProjectsProjectID+"=$1;",
+		to, startedAt,
+	); err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't update running project: %+v", err)
+	}
+
+	return nil
+}
+
+// updateWorktime recalculates the worktime of every day between from and to,
+// in the time zone of the user.
+func (r *repositoryImpl) updateWorktime(tx *sql.Tx, userID string, from, to time.Time) error {
+	location, err := r.GetLocation(userID)
+	if err != nil {
+		return err
+	}
+
+	dayStart, _ := DayBounds(from.In(location), location)
+	for {
+		if err := r.updateWorktimeOfDay(tx, userID, dayStart, location); err != nil {
+			return err
+		}
+
+		dayStart = dayStart.AddDate(0, 0, 1)
+		if !dayStart.Before(to) {
+			return nil
+		}
+	}
+}
+
+// updateWorktimeOfDay recalculates the worktime of the day starting at dayStart
+// and adjusts the overtime balance by the difference.
+func (r *repositoryImpl) updateWorktimeOfDay(tx *sql.Tx, userID string, dayStart time.Time, location *time.Location) error {
+	// lock the overtime balance of the user first, so that concurrent updates
+	// of the same user are serialized and the balance can't drift
+	if err := r.lockOvertimeWithTx(tx, userID); err != nil {
+		return err
+	}
+
+	previous, err := r.readWorktimeOfDay(tx, userID, dayStart)
+	if err != nil {
+		return err
+	}
+
+	current, err := r.calculateWorktimeOfDay(tx, userID, dayStart, location)
+	if err != nil {
+		return err
+	}
+
+	return r.writeWorktimeOfDay(tx, userID, dayStart, previous, current)
+}
+
+// readWorktimeOfDay returns the stored worktime of the day starting at
+// dayStart or nil, if there is none.
+func (r *repositoryImpl) readWorktimeOfDay(tx *sql.Tx, userID string, dayStart time.Time) (*Worktime, error) {
+	worktime := &Worktime{
+		UserID: userID,
+		Day:    dayStart,
+	}
+	err := r.database.QueryRowWithTx(
+		tx,
+		"SELECT "+columnWorktimeWorktime+", "+columnWorktimeBreaktime+
+			" FROM "+tableWorktime+
+			" WHERE "+columnWorktimeUserID+"=$1 AND "+columnWorktimeDay+"=$2::date;",
+		[]any{userID, dayStart.Format(time.DateOnly)},
+		&worktime.Worktime,
+		&worktime.Breaktime,
+	)
+	if err != nil {
+		switch {
+		case errors.As(err, &database.NoRowsError{}):
+			return nil, nil
+		default:
+			return nil, r.logger.LogAndAbstractError("database error", "Couldn't read worktime: %+v", err)
+		}
+	}
+
+	return worktime, nil
+}
+
+// calculateWorktimeOfDay calculates the worktime of the day starting at
+// dayStart from the activities or returns nil, if there are no activities.
+func (r *repositoryImpl) calculateWorktimeOfDay(tx *sql.Tx, userID string, dayStart time.Time, location *time.Location) (*Worktime, error) {
+	activities, err := r.getActivitiesWithTx(tx, userID, dayStart)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(activities) == 0 {
+		return nil, nil
+	}
+
+	dailyActivities := DailyActivities{
+		Activities: activities,
+	}
+	dailyActivities.CalculateForDay(DayBounds(dayStart, location))
+
+	return &Worktime{
+		UserID:    userID,
+		Day:       dayStart,
+		Worktime:  uint(dailyActivities.Worktime),
+		Breaktime: uint(dailyActivities.Breaktime),
+	}, nil
+}
+
+// writeWorktimeOfDay replaces the previous worktime of the day with the
+// current one and adjusts the overtime balance by the difference. Days without
+// any activity don't keep a worktime. The overtime balance of the user must
+// already be locked within the transaction.
+func (r *repositoryImpl) writeWorktimeOfDay(tx *sql.Tx, userID string, dayStart time.Time, previous, current *Worktime) error {
+	day := dayStart.Format(time.DateOnly)
+
+	var previousOvertime int64
+	if previous != nil {
+		previousOvertime = previous.Overtime()
+	}
+
+	var overtime int64
+	if current == nil {
+		_, err := r.database.ExecWithTx(
+			tx,
+			"DELETE FROM "+tableWorktime+
+				" WHERE "+columnWorktimeUserID+"=$1 AND "+columnWorktimeDay+"=$2::date;",
+			userID, day)
+		if err != nil {
+			return r.logger.LogAndAbstractError("database error", "Couldn't delete worktime: %+v", err)
+		}
+	} else {
+		_, err := r.database.ExecWithTx(
+			tx,
+			"INSERT INTO "+tableWorktime+
+				" ("+columnWorktimeUserID+", "+columnWorktimeDay+", "+columnWorktimeWorktime+", "+columnWorktimeBreaktime+")"+
+				" VALUES ($1, $2::date, $3, $4)"+
+				" ON CONFLICT ("+columnWorktimeUserID+", "+columnWorktimeDay+")"+
+				" DO UPDATE SET "+columnWorktimeWorktime+"=$3, "+columnWorktimeBreaktime+"=$4;",
+			userID, day, current.Worktime, current.Breaktime)
+		if err != nil {
+			return r.logger.LogAndAbstractError("database error", "Couldn't update worktime: %+v", err)
+		}
+
+		overtime = current.Overtime()
+	}
+
+	_, err := r.database.ExecWithTx(
+		tx,
+		"UPDATE "+tableOvertime+
+			" SET "+columnOvertimeBalance+"="+columnOvertimeBalance+"+$2"+
+			" WHERE "+columnOvertimeUserID+"=$1;",
+		userID, overtime-previousOvertime)
+	if err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't update overtime: %+v", err)
+	}
+
+	return nil
+}
+
+// RebuildWorktime recalculates the worktime of the given days from the
+// activities within a single transaction and returns every day, whose stored
+// worktime differed. In a dry run, nothing is written.
+func (r *repositoryImpl) RebuildWorktime(userID string, days []time.Time, dryRun bool) ([]*WorktimeDiff, error) {
+	location, err := r.GetLocation(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []*WorktimeDiff
+	err = r.inTransaction("rebuilding worktime", func(tx *sql.Tx) error {
+		if !dryRun {
+			if err := r.lockOvertimeWithTx(tx, userID); err != nil {
+				return err
+			}
+		}
+
+		for _, day := range days {
+			dayStart, _ := DayBounds(day, location)
+
+			stored, err := r.readWorktimeOfDay(tx, userID, dayStart)
+			if err != nil {
+				return err
+			}
+
+			expected, err := r.calculateWorktimeOfDay(tx, userID, dayStart, location)
+			if err != nil {
+				return err
+			}
+
+			diff := &WorktimeDiff{
+				UserID:   userID,
+				Day:      dayStart,
+				Stored:   stored,
+				Expected: expected,
+			}
+			if !diff.Differs() {
+				continue
+			}
+			diffs = append(diffs, diff)
+
+			if !dryRun {
+				if err := r.writeWorktimeOfDay(tx, userID, dayStart, stored, expected); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return diffs, nil
+}
+
+// RebuildAllWorktime recalculates the worktime of every day, on which the user
+// tracked time, after the boundaries of their days changed with the time zone.
+// Worktime stored for the days of the previous time zone is replaced. The days
+// are rebuilt in batches, so that the overtime of the user isn't locked for
+// long.
+func (r *repositoryImpl) RebuildAllWorktime(userID string) error {
+	location, err := r.GetLocation(userID)
+	if err != nil {
+		return err
+	}
+
+	var firstStartedAt, firstDay, lastDay sql.NullTime
+	if err := r.database.QueryRow(
+		"SELECT"+
+			" (SELECT MIN(a."+columnsActivitiesStartedAt+")"+
+			" FROM "+tableActvities+" a"+
+			" JOIN "+tableProjects+" p ON a."+columnsActivitiesProjectID+"=p."+columnProjectsProjectID+
+			" WHERE p."+columnProjectsUserID+"=$1)"+
+			", (SELECT MIN("+columnWorktimeDay+") FROM "+tableWorktime+" WHERE "+columnWorktimeUserID+"=$1)"+
+			", (SELECT MAX("+columnWorktimeDay+") FROM "+tableWorktime+" WHERE "+columnWorktimeUserID+"=$1);",
+		[]any{userID},
+		&firstStartedAt,
+		&firstDay,
+		&lastDay,
+	); err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't read tracked days: %+v", err)
+	}
+
+	if !firstStartedAt.Valid && !firstDay.Valid {
+		return nil
+	}
+
+	// stored days are dates, whose boundaries are taken from the new time zone
+	first, _ := DayBounds(time.Now().In(location), location)
+	last := first
+	if firstStartedAt.Valid {
+		if start, _ := DayBounds(firstStartedAt.Time.In(location), location); start.Before(first) {
+			first = start
+		}
+	}
+	if firstDay.Valid {
+		if start, _ := DayBounds(firstDay.Time, location); start.Before(first) {
+			first = start
+		}
+	}
+	if lastDay.Valid {
+		if start, _ := DayBounds(lastDay.Time, location); start.After(last) {
+			last = start
+		}
+	}
+
+	var days []time.Time
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		days = append(days, day)
+	}
+
+	for start := 0; start < len(days); start += rebuildBatchSize {
+		end := min(start+rebuildBatchSize, len(days))
+		if _, err := r.RebuildWorktime(userID, days[start:end], false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *repositoryImpl) lockOvertimeWithTx(tx *sql.Tx, userID string) error {
+	if _, err := r.database.ExecWithTx(
+		tx,
+		"INSERT INTO "+tableOvertime+
+			" ("+columnOvertimeUserID+")"+
+			" VALUES ($1)"+
+			" ON CONFLICT ("+columnOvertimeUserID+") DO NOTHING;",
+		userID,
+	); err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't create overtime: %+v", err)
+	}
+
+	var balance int64
+	if err := r.database.QueryRowWithTx(
+		tx,
+		"SELECT "+columnOvertimeBalance+
+			" FROM "+tableOvertime+
+			" WHERE "+columnOvertimeUserID+"=$1"+
+			" FOR UPDATE;",
+		[]any{userID},
+		&balance,
+	); err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't lock overtime: %+v", err)
+	}
+
+	return nil
+}
+
+func (r *repositoryImpl) GetWorktime(userID string) ([]*Worktime, error) {
+	rows, err := r.database.Query(
+		"SELECT "+columnWorktimeDay+", "+columnWorktimeWorktime+", "+columnWorktimeBreaktime+
+			" FROM "+tableWorktime+
+			" WHERE "+columnWorktimeUserID+"=$1"+
+			" ORDER BY "+columnWorktimeDay+" DESC;",
+		userID)
+	if err != nil {
+		return nil, r.logger.LogAndAbstractError("database error", "Couldn't get worktime: %+v", err)
+	}
+	defer rows.Close()
+
+	var worktimes []*Worktime
+	for rows.Next() {
+		var worktime = &Worktime{}
+		var day time.Time
+		if err := rows.Scan(&day, &worktime.Worktime, &worktime.Breaktime); err != nil {
+			return nil, r.logger.LogAndAbstractError("database error", "Couldn't scan worktime: %+v", err)
+		}
+		worktime.UserID = userID
+		worktime.Day = day
+		worktimes = append(worktimes, worktime)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, r.logger.LogAndAbstractError("database error", "Error iterating worktime rows: %+v", err)
+	}
+
+	return worktimes, nil
+}
+
+// GetTrackingUserIDs returns all users, which have projects, worktime or an
+// overtime balance.
+func (r *repositoryImpl) GetTrackingUserIDs() ([]string, error) {
+	rows, err := r.database.Query(
+		"SELECT " + columnProjectsUserID + " FROM " + tableProjects +
+			" UNION" +
+			" SELECT " + columnWorktimeUserID + " FROM " + tableWorktime +
+			" UNION" +
+			" SELECT " + columnOvertimeUserID + " FROM " + tableOvertime +
+			" ORDER BY 1;",
+	)
+	if err != nil {
+		return nil, r.logger.LogAndAbstractError("database error", "Couldn't get users: %+v", err)
+	}
+	defer rows.Close()
+
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, r.logger.LogAndAbstractError("database error", "Couldn't scan user: %+v", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, r.logger.LogAndAbstractError("database error", "Error iterating user rows: %+v", err)
+	}
+
+	return userIDs, nil
+}
+
+func (r *repositoryImpl) GetOvertime(userID string) (int64, error) {
+	var balance int64
+	if err := r.database.QueryRow(
+		"SELECT "+columnOvertimeBalance+
+			" FROM "+tableOvertime+
+			" WHERE "+columnOvertimeUserID+"=$1;",
+		[]any{userID},
+		&balance,
+	); err != nil {
+		switch {
+		case errors.As(err, &database.NoRowsError{}):
+			return 0, nil
+		default:
+			return 0, r.logger.LogAndAbstractError("database error", "Couldn't get overtime: %+v", err)
+		}
+	}
+
+	return balance, nil
+}
+
+func (r *repositoryImpl) SetOvertime(userID string, balance int64) error {
+	_, err := r.database.Exec(
+		"INSERT INTO "+tableOvertime+
+			" ("+columnOvertimeUserID+", "+columnOvertimeBalance+")"+
+			" VALUES ($1, $2)"+
+			" ON CONFLICT ("+columnOvertimeUserID+")"+
+			" DO UPDATE SET "+columnOvertimeBalance+"=$2;",
+		userID, balance)
+	if err != nil {
+		return r.logger.LogAndAbstractError("database error", "Couldn't set overtime: %+v", err)
+	}
+
+	return nil
 }
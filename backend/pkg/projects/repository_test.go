@@ -0,0 +1,179 @@
+package projects
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DominikKuenkele/TimeTrack/libraries/database"
+	"github.com/DominikKuenkele/TimeTrack/libraries/database/databasetest"
+)
+
+// newTestRepository creates a repository and a user, which is deleted after the
+// test.
+func newTestRepository(t *testing.T) (Repository, database.Database, string) {
+	t.Helper()
+
+	db, log := databasetest.NewDatabase(t)
+
+	repository, err := NewRepository(log, db)
+	if err != nil {
+		t.Fatalf("error creating repository: %+v", err)
+	}
+
+	return repository, db, databasetest.CreateUser(t, db)
+}
+
+// runConcurrently calls f for all requests at the same time and returns their
+// errors.
+func runConcurrently(requests int, f func(i int) error) []error {
+	var (
+		wg    sync.WaitGroup
+		errs  = make([]error, requests)
+		start = make(chan struct{})
+	)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			errs[i] = f(i)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	return errs
+}
+
+// countSucceeded counts the requests without an error and checks, that all
+// others failed with expected.
+func countSucceeded(t *testing.T, errs []error, expected error) int {
+	t.Helper()
+
+	succeeded := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, expected):
+			t.Errorf("expected %v, got %+v", expected, err)
+		}
+	}
+
+	return succeeded
+}
+
+// countRunningActivities counts the open activities of the user.
+func countRunningActivities(t *testing.T, db database.Database, userID string) int {
+	t.Helper()
+
+	var running int
+	if err := db.QueryRow(
+		"SELECT COUNT(*) FROM activities WHERE user_id=$1 AND ended_at IS NULL;",
+		[]any{userID},
+		&running,
+	); err != nil {
+		t.Fatalf("error counting running activities: %+v", err)
+	}
+
+	return running
+}
+
+func TestStartProjectConcurrently(t *testing.T) {
+	repository, db, userID := newTestRepository(t)
+
+	if err := repository.AddProject(userID, "project"); err != nil {
+		t.Fatalf("error adding project: %+v", err)
+	}
+
+	now := time.Now()
+	errs := runConcurrently(10, func(int) error {
+		return repository.StartProject(userID, "project", now, nil)
+	})
+
+	if started := countSucceeded(t, errs, ErrAlreadyStarted); started != 1 {
+		t.Errorf("expected 1 successful start, got %d", started)
+	}
+	if running := countRunningActivities(t, db, userID); running != 1 {
+		t.Errorf("expected 1 running activity, got %d", running)
+	}
+}
+
+func TestStartDifferentProjectsConcurrently(t *testing.T) {
+	repository, db, userID := newTestRepository(t)
+
+	const requests = 5
+	for i := 0; i < requests; i++ {
+		if err := repository.AddProject(userID, fmt.Sprintf("project-%d", i)); err != nil {
+			t.Fatalf("error adding project: %+v", err)
+		}
+	}
+
+	// every start stops the project, which was started before it
+	now := time.Now()
+	errs := runConcurrently(requests, func(i int) error {
+		return repository.StartProject(userID, fmt.Sprintf("project-%d", i), now, nil)
+	})
+
+	for _, err := range errs {
+		if err != nil {
+			t.Errorf("expected all starts to succeed, got %+v", err)
+		}
+	}
+	if running := countRunningActivities(t, db, userID); running != 1 {
+		t.Errorf("expected 1 running activity, got %d", running)
+	}
+
+	var started int
+	if err := db.QueryRow(
+		"SELECT COUNT(*) FROM projects WHERE user_id=$1 AND started_at IS NOT NULL;",
+		[]any{userID},
+		&started,
+	); err != nil {
+		t.Fatalf("error counting started projects: %+v", err)
+	}
+	if started != 1 {
+		t.Errorf("expected 1 started project, got %d", started)
+	}
+}
+
+func TestStopProjectConcurrently(t *testing.T) {
+	repository, db, userID := newTestRepository(t)
+
+	if err := repository.AddProject(userID, "project"); err != nil {
+		t.Fatalf("error adding project: %+v", err)
+	}
+
+	startedAt := time.Date(2024, time.March, 5, 9, 0, 0, 0, time.UTC)
+	if err := repository.StartProject(userID, "project", startedAt, nil); err != nil {
+		t.Fatalf("error starting project: %+v", err)
+	}
+
+	endedAt := startedAt.Add(time.Hour)
+	errs := runConcurrently(10, func(int) error {
+		_, err := repository.StopProject(userID, "project", endedAt, nil)
+		return err
+	})
+
+	if stopped := countSucceeded(t, errs, ErrNotRunning); stopped != 1 {
+		t.Errorf("expected 1 successful stop, got %d", stopped)
+	}
+	if running := countRunningActivities(t, db, userID); running != 0 {
+		t.Errorf("expected no running activity, got %d", running)
+	}
+
+	worktimes, err := repository.GetWorktime(userID)
+	if err != nil {
+		t.Fatalf("error getting worktime: %+v", err)
+	}
+	var worktime uint
+	for _, w := range worktimes {
+		worktime += w.Worktime
+	}
+	if worktime != uint(time.Hour.Seconds()) {
+		t.Errorf("expected a worktime of %d seconds, got %d", uint(time.Hour.Seconds()), worktime)
+	}
+}